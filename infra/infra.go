@@ -14,17 +14,25 @@
 package main // import "github.com/prometheus/test-infra/infra"
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/prometheus/test-infra/pkg/provider"
-	"github.com/prometheus/test-infra/pkg/provider/eks"
-	"github.com/prometheus/test-infra/pkg/provider/gke"
-	"github.com/prometheus/test-infra/pkg/provider/kind"
+	// Register the providers.
+	_ "github.com/prometheus/test-infra/pkg/provider/aks"
+	_ "github.com/prometheus/test-infra/pkg/provider/eks"
+	_ "github.com/prometheus/test-infra/pkg/provider/gke"
+	_ "github.com/prometheus/test-infra/pkg/provider/kind"
+	_ "github.com/prometheus/test-infra/pkg/provider/kubeconfig"
 )
 
 func main() {
@@ -32,6 +40,12 @@ func main() {
 
 	dr := provider.NewDeploymentResource()
 
+	// Abort any in-flight provider requests and waits on Ctrl-C, when the CI job is terminated or on --timeout.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	app := kingpin.New(filepath.Base(os.Args[0]), "The prometheus/test-infra deployment tool")
 	app.HelpFlag.Short('h')
 	app.Flag("file", "yaml file or folder  that describes the parameters for the object that will be deployed.").
@@ -40,121 +54,79 @@ func main() {
 	app.Flag("vars", "When provided it will substitute the token holders in the yaml file. Follows the standard golang template formating - {{ .hashStable }}.").
 		Short('v').
 		StringMapVar(&dr.FlagDeploymentVars)
-
-	g := gke.New(dr)
-	k8sGKE := app.Command("gke", `Google container engine provider - https://cloud.google.com/kubernetes-engine/`).
-		Action(g.SetupDeploymentResources)
-	k8sGKE.Flag("auth", "json authentication for the project. Accepts a filepath or an env variable that includes tha json data. If not set the tool will use the GOOGLE_APPLICATION_CREDENTIALS env variable (export GOOGLE_APPLICATION_CREDENTIALS=service-account.json). https://cloud.google.com/iam/docs/creating-managing-service-account-keys.").
-		PlaceHolder("service-account.json").
-		Short('a').
-		StringVar(&g.Auth)
-
-	k8sGKE.Command("info", "gke info -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(g.GetDeploymentVars)
-
-	// Cluster operations.
-	k8sGKECluster := k8sGKE.Command("cluster", "manage GKE clusters").
-		Action(g.NewGKEClient).
-		Action(g.GKEDeploymentsParse)
-	k8sGKECluster.Command("create", "gke cluster create -a service-account.json -f FileOrFolder").
-		Action(g.ClusterCreate)
-	k8sGKECluster.Command("delete", "gke cluster delete -a service-account.json -f FileOrFolder").
-		Action(g.ClusterDelete)
-
-	// Cluster node-pool operations
-	k8sGKENodePool := k8sGKE.Command("nodes", "manage GKE clusters nodepools").
-		Action(g.NewGKEClient).
-		Action(g.GKEDeploymentsParse)
-	k8sGKENodePool.Command("create", "gke nodes create -a service-account.json -f FileOrFolder").
-		Action(g.NodePoolCreate)
-	k8sGKENodePool.Command("delete", "gke nodes delete -a service-account.json -f FileOrFolder").
-		Action(g.NodePoolDelete)
-	k8sGKENodePool.Command("check-running", "gke nodes check-running -a service-account.json -f FileOrFolder").
-		Action(g.AllNodepoolsRunning)
-	k8sGKENodePool.Command("check-deleted", "gke nodes check-deleted -a service-account.json -f FileOrFolder").
-		Action(g.AllNodepoolsDeleted)
-
-	// K8s resource operations.
-	k8sGKEResource := k8sGKE.Command("resource", `Apply and delete different k8s resources - deployments, services, config maps etc.Required variables -v GKE_PROJECT_ID, -v ZONE: -west1-b -v CLUSTER_NAME`).
-		Action(g.NewGKEClient).
-		Action(g.K8SDeploymentsParse).
-		Action(g.NewK8sProvider)
-	k8sGKEResource.Command("apply", "gke resource apply -a service-account.json -f manifestsFileOrFolder -v GKE_PROJECT_ID:test -v ZONE:europe-west1-b -v CLUSTER_NAME:test -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(g.ResourceApply)
-	k8sGKEResource.Command("delete", "gke resource delete -a service-account.json -f manifestsFileOrFolder -v GKE_PROJECT_ID:test -v ZONE:europe-west1-b -v CLUSTER_NAME:test -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(g.ResourceDelete)
-
-	k := kind.New(dr)
-	k8sKIND := app.Command("kind", `Kubernetes In Docker (KIND) provider - https://kind.sigs.k8s.io/docs/user/quick-start/`).
-		Action(k.SetupDeploymentResources)
-
-	k8sKIND.Command("info", "kind info -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(k.GetDeploymentVars)
-
-	// Cluster operations.
-	k8sKINDCluster := k8sKIND.Command("cluster", "manage KIND clusters").
-		Action(k.KINDDeploymentsParse)
-	k8sKINDCluster.Command("create", "kind cluster create -f File -v PR_NUMBER:$PR_NUMBER -v CLUSTER_NAME:$CLUSTER_NAME").
-		Action(k.ClusterCreate)
-	k8sKINDCluster.Command("delete", "kind cluster delete -f File -v PR_NUMBER:$PR_NUMBER -v CLUSTER_NAME:$CLUSTER_NAME").
-		Action(k.ClusterDelete)
-
-	// K8s resource operations.
-	k8sKINDResource := k8sKIND.Command("resource", `Apply and delete different k8s resources - deployments, services, config maps etc.`).
-		Action(k.NewK8sProvider).
-		Action(k.K8SDeploymentsParse)
-	k8sKINDResource.Command("apply", "kind resource apply -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(k.ResourceApply)
-	k8sKINDResource.Command("delete", "kind resource delete -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(k.ResourceDelete)
-
-	// EKS based commands
-	e := eks.New(dr)
-	k8sEKS := app.Command("eks", "Amazon Elastic Kubernetes Service - https://aws.amazon.com/eks").
-		Action(e.SetupDeploymentResources)
-	k8sEKS.Flag("auth", "filename which consist eks credentials.").
-		PlaceHolder("credentials").
-		Short('a').
-		StringVar(&e.Auth)
-
-	k8sEKS.Command("info", "eks info -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(e.GetDeploymentVars)
-
-	// EKS Cluster operations
-	k8sEKSCluster := k8sEKS.Command("cluster", "manage EKS clusters").
-		Action(e.NewEKSClient).
-		Action(e.EKSDeploymentParse)
-	k8sEKSCluster.Command("create", "eks cluster create -a credentials -f FileOrFolder").
-		Action(e.ClusterCreate)
-	k8sEKSCluster.Command("delete", "eks cluster delete -a credentials -f FileOrFolder").
-		Action(e.ClusterDelete)
-
-	// Cluster node-pool operations
-	k8sEKSNodeGroup := k8sEKS.Command("nodes", "manage EKS clusters nodegroups").
-		Action(e.NewEKSClient).
-		Action(e.EKSDeploymentParse)
-	k8sEKSNodeGroup.Command("create", "eks nodes create -a authFile -f FileOrFolder -v ZONE:eu-west-1 -v CLUSTER_NAME:test -v EKS_SUBNET_IDS: subnetId1,subnetId2,subnetId3").
-		Action(e.NodeGroupCreate)
-	k8sEKSNodeGroup.Command("delete", "eks nodes delete -a authFile -f FileOrFolder -v ZONE:eu-west-1 -v CLUSTER_NAME:test -v EKS_SUBNET_IDS: subnetId1,subnetId2,subnetId3").
-		Action(e.NodeGroupDelete)
-	k8sEKSNodeGroup.Command("check-running", "eks nodes check-running -a credentials -f FileOrFolder -v ZONE:eu-west-1 -v CLUSTER_NAME:test -v EKS_SUBNET_IDS: subnetId1,subnetId2,subnetId3").
-		Action(e.AllNodeGroupsRunning)
-	k8sEKSNodeGroup.Command("check-deleted", "eks nodes check-deleted -a authFile -f FileOrFolder -v ZONE:eu-west-1 -v CLUSTER_NAME:test -v EKS_SUBNET_IDS: subnetId1,subnetId2,subnetId3").
-		Action(e.AllNodeGroupsDeleted)
-
-	// K8s resource operations.
-	k8sEKSResource := k8sEKS.Command("resource", `Apply and delete different k8s resources - deployments, services, config maps etc.Required variables -v ZONE:us-east-2 -v CLUSTER_NAME:test `).
-		Action(e.NewEKSClient).
-		Action(e.K8SDeploymentsParse).
-		Action(e.NewK8sProvider)
-	k8sEKSResource.Command("apply", "eks resource apply -a credentials -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(e.ResourceApply)
-	k8sEKSResource.Command("delete", "eks resource delete -a credentials -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(e.ResourceDelete)
+	app.Flag("vars-file", "yaml file mapping variable names to their values, with ${ENV} references expanded. Can be repeated, later files override earlier ones and --vars overrides all files.").
+		PlaceHolder("FILE").
+		ExistingFilesVar(&dr.VarsFiles)
+	var timeout time.Duration
+	app.Flag("timeout", "Abort the command, including any waits for clusters or resources to become ready, when it runs longer than this duration. 0 means no timeout.").
+		Default("0").
+		DurationVar(&timeout)
+	app.PreAction(func(*kingpin.ParseContext) error {
+		if timeout > 0 {
+			time.AfterFunc(timeout, func() {
+				cancel(fmt.Errorf("timeout of %v reached: %w", timeout, context.DeadlineExceeded))
+			})
+		}
+		return nil
+	})
+
+	// The validator runs once the command line is parsed, so any later error comes from running the command.
+	parsed := false
+	app.Validate(func(*kingpin.Application) error {
+		parsed = true
+		return nil
+	})
+
+	r := &render{DeploymentResource: dr}
+	renderCmd := app.Command("render", "render -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(r.Render)
+	renderCmd.Flag("output-dir", "Directory to write the rendered files to, using their original file names. When not set all files are written to stdout as a single multi-document yaml.").
+		PlaceHolder("DIR").
+		StringVar(&r.OutputDir)
+
+	v := &validate{DeploymentResource: dr}
+	validateCmd := app.Command("validate", "validate -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(v.Validate)
+	validateCmd.Flag("kubeconfig", "kubeconfig file of a cluster to look up the scope of the kinds in, including its custom resources. When not set no cluster is used.").
+		PlaceHolder("FILE").
+		StringVar(&v.Kubeconfig.Path)
+	validateCmd.Flag("context", "kubeconfig context of the cluster, also selects the cluster from the KUBECONFIG env variable or ~/.kube/config when --kubeconfig isn't set.").
+		StringVar(&v.Kubeconfig.Context)
+
+	for _, reg := range provider.Registered() {
+		providerCommand(ctx, app, dr, reg)
+	}
 
 	if _, err := app.Parse(os.Args[1:]); err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("Error parsing commandline arguments: %w", err))
-		app.Usage(os.Args[1:])
-		os.Exit(2)
+		if !parsed || errors.Is(err, kingpin.ErrCommandNotSpecified) {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("Error parsing commandline arguments: %w", err))
+			// app.Usage exits by itself when the arguments can't be parsed at all.
+			if pc, err := app.ParseContext(os.Args[1:]); err == nil {
+				_ = app.UsageForContext(pc)
+			}
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the exit status for an error of a command, so that scripts can tell
+// broken manifests apart from failing cloud provider requests.
+func exitCode(err error) int {
+	var (
+		templateErr *provider.TemplateError
+		decodeErr   *provider.DecodeError
+		cloudAPIErr *provider.CloudAPIError
+	)
+	switch {
+	case errors.As(err, &templateErr), errors.As(err, &decodeErr):
+		return 3
+	case errors.As(err, &cloudAPIErr):
+		return 4
+	case errors.Is(err, errFlagConflict):
+		return 5
+	default:
+		return 1
 	}
 }
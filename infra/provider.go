@@ -0,0 +1,284 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+	k8sProvider "github.com/prometheus/test-infra/pkg/provider/k8s"
+)
+
+// providerCommands runs the commands of a registered provider.
+type providerCommands struct {
+	provider.Registration
+	p provider.Provider
+
+	// DryRun shows the changes ResourceApply would make without applying them.
+	DryRun bool
+	// ApplyOptions are passed to the k8s provider to configure labelling and pruning of the applied objects.
+	ApplyOptions k8sProvider.ApplyOptions
+	// Output is the format of the resource apply and delete results.
+	// With "json" a JSON report is written to stdout.
+	Output string
+	// The k8s provider used when we work with the manifest files.
+	k8sProvider *k8sProvider.K8s
+	// Final DeploymentFiles files.
+	DeploymentFiles []string
+	// Final DeploymentVars.
+	DeploymentVars map[string]string
+	// DeploymentVarSources is the layer each of the DeploymentVars came from.
+	DeploymentVarSources map[string]string
+	// DeployResource to construct DeploymentVars and DeploymentFiles
+	DeploymentResource *provider.DeploymentResource
+	// Content bytes after parsing the template variables, grouped by filename.
+	resources []provider.Resource
+	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
+	k8sResources []k8sProvider.Resource
+
+	ctx context.Context
+}
+
+// providerCommand adds the command tree of a registered provider to the app.
+func providerCommand(ctx context.Context, app *kingpin.Application, dr *provider.DeploymentResource, reg provider.Registration) {
+	c := &providerCommands{
+		Registration:       reg,
+		p:                  reg.New(ctx),
+		DeploymentResource: dr,
+		ctx:                ctx,
+	}
+	vars := c.requiredVarsUsage()
+
+	cmd := app.Command(c.Name, c.Help).
+		Action(c.SetupDeploymentResources)
+	c.p.Flags(cmd)
+
+	cmd.Command("info", c.Name+" info -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(c.GetDeploymentVars)
+
+	// Cluster and node-pool operations.
+	if cp, ok := c.p.(provider.ClusterProvider); ok {
+		cluster := cmd.Command("cluster", "manage "+strings.ToUpper(c.Name)+" clusters").
+			Action(c.Init).
+			Action(c.DeploymentsParse)
+		cluster.Command("create", c.Name+" cluster create -f FileOrFolder"+vars).
+			Action(c.run(cp.ClusterCreate))
+		cluster.Command("delete", c.Name+" cluster delete -f FileOrFolder"+vars).
+			Action(c.run(cp.ClusterDelete))
+
+		nodes := cmd.Command("nodes", "manage "+strings.ToUpper(c.Name)+" clusters node pools").
+			Action(c.Init).
+			Action(c.DeploymentsParse)
+		nodes.Command("create", c.Name+" nodes create -f FileOrFolder"+vars).
+			Action(c.run(cp.NodePoolCreate))
+		nodes.Command("delete", c.Name+" nodes delete -f FileOrFolder"+vars).
+			Action(c.run(cp.NodePoolDelete))
+		nodes.Command("check-running", c.Name+" nodes check-running -f FileOrFolder"+vars).
+			Action(c.run(cp.NodePoolsRunning))
+		nodes.Command("check-deleted", c.Name+" nodes check-deleted -f FileOrFolder"+vars).
+			Action(c.run(cp.NodePoolsDeleted))
+	}
+
+	// Provider specific operations.
+	if commander, ok := c.p.(provider.Commander); ok {
+		commander.Commands(cmd, c.setup, c.run)
+	}
+
+	// K8s resource operations.
+	resourceHelp := "Apply and delete different k8s resources - deployments, services, config maps etc."
+	if vars != "" {
+		resourceHelp += " Required variables" + vars
+	}
+	resource := cmd.Command("resource", resourceHelp).
+		Action(c.checkApplyFlags).
+		Action(c.Init).
+		Action(c.K8SDeploymentsParse).
+		Action(c.NewK8sProvider)
+	apply := resource.Command("apply", c.Name+" resource apply -f manifestsFileOrFolder"+vars+" -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(c.ResourceApply)
+	apply.Flag("dry-run", "Show the objects that would be created, updated or left unchanged, with a diff against the live cluster state, without applying anything.").
+		BoolVar(&c.DryRun)
+	applyFlags(apply, &c.ApplyOptions)
+	outputFlag(apply, &c.Output)
+	del := resource.Command("delete", c.Name+" resource delete -f manifestsFileOrFolder"+vars+" -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(c.ResourceDelete)
+	outputFlag(del, &c.Output)
+}
+
+// requiredVarsUsage returns the -v flags of the required variables for the help texts.
+func (c *providerCommands) requiredVarsUsage() string {
+	var usage string
+	for _, k := range c.RequiredVars {
+		usage += fmt.Sprintf(" -v %v:$%v", k, k)
+	}
+	return usage
+}
+
+// run returns an action calling a provider method with the parsed deployment files.
+func (c *providerCommands) run(fn func([]provider.Resource) error) kingpin.Action {
+	return func(*kingpin.ParseContext) error {
+		return fn(c.resources)
+	}
+}
+
+// setup runs the Init and DeploymentsParse actions of the cluster and nodes commands for the provider commands.
+func (c *providerCommands) setup(ctx *kingpin.ParseContext) error {
+	if err := c.Init(ctx); err != nil {
+		return err
+	}
+	return c.DeploymentsParse(ctx)
+}
+
+// SetupDeploymentResources Sets up DeploymentVars and DeploymentFiles
+func (c *providerCommands) SetupDeploymentResources(*kingpin.ParseContext) error {
+	layers, err := c.DeploymentResource.VarLayers(c.Name, c.DefaultVars)
+	if err != nil {
+		return err
+	}
+	c.DeploymentFiles = c.DeploymentResource.DeploymentFiles
+	c.DeploymentVars, c.DeploymentVarSources = provider.MergeVarLayers(layers)
+	return nil
+}
+
+// Init checks the required deployment vars and files and initializes the provider.
+func (c *providerCommands) Init(*kingpin.ParseContext) error {
+	for _, k := range c.RequiredVars {
+		if v, ok := c.DeploymentVars[k]; !ok || v == "" {
+			return fmt.Errorf("missing required %v variable", k)
+		}
+	}
+	if len(c.DeploymentFiles) == 0 {
+		return fmt.Errorf("missing deployment file(s)")
+	}
+	return c.p.Init(c.DeploymentVars)
+}
+
+// DeploymentsParse parses the cluster/nodepool deployment files and saves the result as bytes grouped by the filename.
+// Any variables passed to the cli will be replaced in the resources files following the golang text template format.
+func (c *providerCommands) DeploymentsParse(*kingpin.ParseContext) error {
+	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
+	if err != nil {
+		return fmt.Errorf("couldn't parse deployment files: %w", err)
+	}
+	c.resources = deploymentResource
+	return nil
+}
+
+// K8SDeploymentsParse parses the k8s objects deployment files and saves the result as k8s objects grouped by the filename.
+// Any variables passed to the cli will be replaced in the resources files following the golang text template format.
+func (c *providerCommands) K8SDeploymentsParse(*kingpin.ParseContext) error {
+	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
+	if err != nil {
+		return fmt.Errorf("couldn't parse deployment files: %w", err)
+	}
+
+	k8sResources, err := k8sProvider.Decode(deploymentResource)
+	if err != nil {
+		return err
+	}
+	c.k8sResources = append(c.k8sResources, k8sResources...)
+	return nil
+}
+
+// NewK8sProvider sets the k8s provider used for deploying k8s manifests.
+func (c *providerCommands) NewK8sProvider(*kingpin.ParseContext) error {
+	config, err := c.p.KubeConfig()
+	if err != nil {
+		return err
+	}
+	c.k8sProvider, err = k8sProvider.New(c.ctx, config)
+	if err != nil {
+		return fmt.Errorf("k8s provider error: %w", err)
+	}
+	c.k8sProvider.ApplyOptions = c.ApplyOptions
+	if c.Output == "json" {
+		c.k8sProvider.ReportWriter = os.Stdout
+	}
+	return nil
+}
+
+// errFlagConflict is returned when the flags of a command can't be combined.
+var errFlagConflict = errors.New("conflicting flags")
+
+// checkApplyFlags rejects the resource apply flags that can't be combined.
+// It runs before the provider is initialized, so that nothing is requested from the cluster.
+func (c *providerCommands) checkApplyFlags(*kingpin.ParseContext) error {
+	// The dry run writes a text diff, which would break the consumers parsing stdout as JSON.
+	if c.DryRun && c.Output == "json" {
+		return fmt.Errorf("%w: --dry-run can't be combined with --output=json", errFlagConflict)
+	}
+	return nil
+}
+
+// ResourceApply calls k8s.ResourceApply to apply the k8s objects in the manifest files.
+func (c *providerCommands) ResourceApply(*kingpin.ParseContext) error {
+	if c.DryRun {
+		if err := c.k8sProvider.ResourceDiff(c.k8sResources); err != nil {
+			return fmt.Errorf("error while diffing a resource: %w", err)
+		}
+		return nil
+	}
+	if err := c.k8sProvider.ResourceApply(c.k8sResources, true); err != nil {
+		return fmt.Errorf("error while applying a resource: %w", err)
+	}
+	return nil
+}
+
+// ResourceDelete calls k8s.ResourceDelete to delete the k8s objects in the manifest files.
+func (c *providerCommands) ResourceDelete(*kingpin.ParseContext) error {
+	if err := c.k8sProvider.ResourceDelete(c.k8sResources); err != nil {
+		return fmt.Errorf("error while deleting objects from a manifest file: %w", err)
+	}
+	return nil
+}
+
+// GetDeploymentVars shows deployment variables.
+func (c *providerCommands) GetDeploymentVars(*kingpin.ParseContext) error {
+	fmt.Print("-------------------\n   DeploymentVars   \n------------------- \n")
+	provider.PrintDeploymentVars(os.Stdout, c.DeploymentVars, c.DeploymentVarSources)
+
+	if len(c.DeploymentFiles) == 0 {
+		return nil
+	}
+	fmt.Print("-------------------\n   Template variables   \n------------------- \n")
+	return provider.PrintFileVars(os.Stdout, c.DeploymentFiles, c.DeploymentVars)
+}
+
+// applyFlags registers the flags shared by the resource apply commands of all providers.
+func applyFlags(cmd *kingpin.CmdClause, o *k8sProvider.ApplyOptions) {
+	cmd.Flag("inventory", "Label every applied object with this inventory name (label "+k8sProvider.InventoryLabel+"), so that later applies can prune it.").
+		PlaceHolder("NAME").
+		StringVar(&o.Inventory)
+	cmd.Flag("prune", "Delete the objects labelled with the --inventory name that are no longer in the manifests. Namespaced objects are only deleted in the namespaces of the manifests.").
+		BoolVar(&o.Prune)
+	cmd.Flag("prune-all-namespaces", "With --prune, delete the labelled namespaced objects in all namespaces instead of only in the namespaces of the manifests.").
+		BoolVar(&o.PruneAllNamespaces)
+	cmd.Flag("workers", "Number of files of the same wave applied concurrently. The objects of a single file are always applied in order.").
+		Default("4").
+		IntVar(&o.Workers)
+}
+
+// outputFlag registers the flag selecting the format of the resource apply and delete results.
+func outputFlag(cmd *kingpin.CmdClause, output *string) {
+	cmd.Flag("output", "Output format. With json a report of every applied or deleted object, its wave, action, time to readiness and any error is written to stdout, while the logs stay on stderr.").
+		Short('o').
+		Default("text").
+		EnumVar(output, "text", "json")
+}
@@ -0,0 +1,91 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+// render writes the templated deployment files without talking to any provider.
+type render struct {
+	// DeployResource to construct DeploymentVars and DeploymentFiles
+	DeploymentResource *provider.DeploymentResource
+	// OutputDir is the directory the rendered files are written to.
+	// When empty all files are written to stdout as a single multi-document yaml.
+	OutputDir string
+}
+
+// Render applies the template variables to the deployment files and writes the result in wave order.
+func (r *render) Render(*kingpin.ParseContext) error {
+	if len(r.DeploymentResource.DeploymentFiles) == 0 {
+		return fmt.Errorf("missing deployment file(s)")
+	}
+	layers, err := r.DeploymentResource.VarLayers("", nil)
+	if err != nil {
+		return err
+	}
+	deploymentVars, _ := provider.MergeVarLayers(layers)
+	resources, err := provider.DeploymentsParse(r.DeploymentResource.DeploymentFiles, deploymentVars)
+	if err != nil {
+		return fmt.Errorf("couldn't parse deployment files: %w", err)
+	}
+	provider.SortByWave(resources)
+
+	if r.OutputDir == "" {
+		return r.writeStdout(resources)
+	}
+	return r.writeDir(resources)
+}
+
+// writeStdout writes all resources as one multi-document yaml, prefixing every file with its source.
+func (r *render) writeStdout(resources []provider.Resource) error {
+	for i, resource := range resources {
+		if i > 0 {
+			if _, err := fmt.Fprintln(os.Stdout, provider.Separator); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "# Source: %s\n%s\n", resource.FileName, bytes.TrimSpace(resource.Content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDir writes every resource to a file with the same name in the output directory.
+func (r *render) writeDir(resources []provider.Resource) error {
+	if err := os.MkdirAll(r.OutputDir, 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	written := map[string]string{}
+	for _, resource := range resources {
+		name := filepath.Base(resource.FileName)
+		if prev, ok := written[name]; ok {
+			return fmt.Errorf("files %v and %v would both be written to %v", prev, resource.FileName, name)
+		}
+		written[name] = resource.FileName
+
+		if err := os.WriteFile(filepath.Join(r.OutputDir, name), resource.Content, 0o644); err != nil {
+			return fmt.Errorf("writing rendered file %v: %w", name, err)
+		}
+	}
+	return nil
+}
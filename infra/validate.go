@@ -0,0 +1,104 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+	k8sProvider "github.com/prometheus/test-infra/pkg/provider/k8s"
+	"github.com/prometheus/test-infra/pkg/provider/kubeconfig"
+)
+
+// validate checks the deployment files without talking to any provider.
+type validate struct {
+	// DeployResource to construct DeploymentVars and DeploymentFiles
+	DeploymentResource *provider.DeploymentResource
+	// Kubeconfig selects a cluster to look up the scope of the kinds in, no cluster is used when empty.
+	Kubeconfig kubeconfig.Kubeconfig
+}
+
+// Validate renders every deployment file and checks all k8s objects in it.
+// All problems are printed before returning, so that they can be fixed at once.
+func (v *validate) Validate(*kingpin.ParseContext) error {
+	if len(v.DeploymentResource.DeploymentFiles) == 0 {
+		return fmt.Errorf("missing deployment file(s)")
+	}
+	layers, err := v.DeploymentResource.VarLayers("", nil)
+	if err != nil {
+		return err
+	}
+	deploymentVars, _ := provider.MergeVarLayers(layers)
+	files, err := provider.DeploymentFilesList(v.DeploymentResource.DeploymentFiles)
+	if err != nil {
+		return err
+	}
+
+	// Render the files one by one to report the template errors of all files.
+	// Overlays are rendered as a whole as their patches apply to the files of their base.
+	var groups [][]string
+	for _, name := range v.DeploymentResource.DeploymentFiles {
+		if provider.IsOverlay(name) {
+			groups = append(groups, []string{name})
+			continue
+		}
+		list, err := provider.DeploymentFilesList([]string{name})
+		if err != nil {
+			return err
+		}
+		for _, file := range list {
+			groups = append(groups, []string{file})
+		}
+	}
+
+	problems := 0
+	var resources []provider.Resource
+	for _, group := range groups {
+		r, err := provider.DeploymentsParse(group, deploymentVars)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			problems++
+			continue
+		}
+		resources = append(resources, r...)
+	}
+	var mapper meta.RESTMapper
+	if v.Kubeconfig.Path != "" || v.Kubeconfig.Context != "" {
+		config, err := v.Kubeconfig.KubeConfig()
+		if err != nil {
+			return err
+		}
+		if mapper, err = k8sProvider.DiscoveryMapper(config); err != nil {
+			return err
+		}
+	}
+	for _, problem := range k8sProvider.Validate(resources, mapper) {
+		if problem.Warning {
+			fmt.Fprintln(os.Stderr, "warning:", problem)
+			continue
+		}
+		fmt.Fprintln(os.Stderr, problem)
+		problems++
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s) in %d file(s)", problems, len(files))
+	}
+	fmt.Printf("%d file(s) are valid\n", len(files))
+	return nil
+}
@@ -0,0 +1,507 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aks
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"gopkg.in/alecthomas/kingpin.v2"
+	yamlGo "gopkg.in/yaml.v2"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	sigsYaml "sigs.k8s.io/yaml"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+// Resource is a parsed AKS deployment file, an alias of provider.Resource.
+type Resource = provider.Resource
+
+func init() {
+	provider.Register(provider.Registration{
+		Name:         "aks",
+		Help:         "Azure Kubernetes Service - https://azure.microsoft.com/products/kubernetes-service",
+		RequiredVars: []string{"AKS_SUBSCRIPTION_ID"},
+		New:          func(ctx context.Context) provider.Provider { return New(ctx) },
+	})
+}
+
+// apiError wraps the error of a failed ARM API request.
+func apiError(op string, err error) error {
+	return &provider.CloudAPIError{Provider: "aks", Op: op, Err: err}
+}
+
+// statusCode returns the HTTP status code of a failed ARM API request, or 0 for any other error.
+func statusCode(err error) int {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+	return 0
+}
+
+// aksCluster is the content of the cluster and nodes deployment files.
+// The cluster and the node pools are ARM resources, with the same fields as the ARM REST API.
+type aksCluster struct {
+	ResourceGroup string                             `json:"resourcegroup"`
+	Cluster       armcontainerservice.ManagedCluster `json:"cluster"`
+	NodePools     []armcontainerservice.AgentPool    `json:"nodepools"`
+}
+
+// azureCredentials is used for YAML unmarshaling of the service principal credential files.
+type azureCredentials struct {
+	TenantID     string `yaml:"tenantid"`
+	ClientID     string `yaml:"clientid"`
+	ClientSecret string `yaml:"clientsecret"`
+}
+
+// AKS holds the fields used to generate an API request.
+type AKS struct {
+	// The auth used to authenticate the cli.
+	// Can be a file path or an env variable that includes the service principal credentials.
+	Auth string
+	// ClusterFile is the cluster deployment file the resource group and the name of the cluster
+	// are read from for the resource commands.
+	ClusterFile string
+	// Admin selects the cluster admin credentials instead of the cluster user credentials for the resource commands.
+	Admin bool
+	// The ARM clients used when performing AKS requests.
+	clientClusters  *armcontainerservice.ManagedClustersClient
+	clientNodePools *armcontainerservice.AgentPoolsClient
+	// credential and clientOptions are set by the tests to use a fake ARM API.
+	credential    azcore.TokenCredential
+	clientOptions *arm.ClientOptions
+	// pollFrequency is the delay between the polls of a long-running operation, set by the tests.
+	// The ARM default is used when it is zero.
+	pollFrequency time.Duration
+	// Final DeploymentVars.
+	DeploymentVars map[string]string
+
+	ctx context.Context
+}
+
+// New is the AKS constructor.
+// All API requests and waits are aborted when ctx is cancelled.
+func New(ctx context.Context) *AKS {
+	return &AKS{
+		ctx: ctx,
+	}
+}
+
+// Flags registers the auth flag and the flags selecting the cluster credentials.
+func (c *AKS) Flags(cmd *kingpin.CmdClause) {
+	cmd.Flag("auth", "yaml file with the tenantid, clientid and clientsecret of a service principal. Accepts a filepath or an env variable that includes the yaml data. If not set the tool will use the AZURE_APPLICATION_CREDENTIALS env variable.").
+		PlaceHolder("credentials").
+		Short('a').
+		StringVar(&c.Auth)
+	cmd.Flag("cluster-file", "Cluster deployment file the resource commands read the resource group and the name of the cluster from. It is templated like the deployment files.").
+		PlaceHolder("FILE").
+		StringVar(&c.ClusterFile)
+	cmd.Flag("admin", "Use the cluster admin credentials for the resource commands instead of the cluster user credentials.").
+		BoolVar(&c.Admin)
+}
+
+// Init sets the ARM clients used when performing AKS requests.
+func (c *AKS) Init(deploymentVars map[string]string) error {
+	c.DeploymentVars = deploymentVars
+
+	if c.credential == nil {
+		cred, err := c.newCredential()
+		if err != nil {
+			return err
+		}
+		c.credential = cred
+	}
+
+	var err error
+	subscription := c.DeploymentVars["AKS_SUBSCRIPTION_ID"]
+	if c.clientClusters, err = armcontainerservice.NewManagedClustersClient(subscription, c.credential, c.clientOptions); err != nil {
+		return fmt.Errorf("could not create the aks client: %w", err)
+	}
+	if c.clientNodePools, err = armcontainerservice.NewAgentPoolsClient(subscription, c.credential, c.clientOptions); err != nil {
+		return fmt.Errorf("could not create the aks client: %w", err)
+	}
+	return nil
+}
+
+// newCredential returns the service principal credential of the auth flag or env variable.
+func (c *AKS) newCredential() (azcore.TokenCredential, error) {
+	if c.Auth != "" {
+	} else if c.Auth = os.Getenv("AZURE_APPLICATION_CREDENTIALS"); c.Auth == "" {
+		return nil, fmt.Errorf("no auth provided set the auth flag or the AZURE_APPLICATION_CREDENTIALS env variable")
+	}
+
+	// When the auth variable points to a file
+	// put the file content in the variable.
+	if content, err := os.ReadFile(c.Auth); err == nil {
+		c.Auth = string(content)
+	}
+
+	// Check if auth data is base64 encoded and decode it.
+	encoded, err := regexp.MatchString("^([A-Za-z0-9+/]{4})*([A-Za-z0-9+/]{3}=|[A-Za-z0-9+/]{2}==)?$", c.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if encoded {
+		auth, err := base64.StdEncoding.DecodeString(c.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode auth data: %w", err)
+		}
+		c.Auth = string(auth)
+	}
+
+	cred := &azureCredentials{}
+	if err = yamlGo.UnmarshalStrict([]byte(c.Auth), cred); err != nil {
+		return nil, fmt.Errorf("could not get credential values: %w", err)
+	}
+	credential, err := azidentity.NewClientSecretCredential(cred.TenantID, cred.ClientID, cred.ClientSecret, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the azure credential: %w", err)
+	}
+	return credential, nil
+}
+
+// decode parses a cluster or nodes deployment file.
+func decode(deployment Resource) (*aksCluster, error) {
+	req := &aksCluster{}
+	if err := sigsYaml.UnmarshalStrict(deployment.Content, req); err != nil {
+		return nil, &provider.DecodeError{File: deployment.FileName, Err: err}
+	}
+	if req.ResourceGroup == "" || req.Cluster.Name == nil {
+		return nil, &provider.DecodeError{File: deployment.FileName, Err: errors.New("missing resourcegroup or cluster name")}
+	}
+	for _, pool := range req.NodePools {
+		if pool.Name == nil {
+			return nil, &provider.DecodeError{File: deployment.FileName, Err: errors.New("node pool without a name")}
+		}
+	}
+	return req, nil
+}
+
+// ClusterCreate create a new cluster or applies changes to an existing cluster.
+func (c *AKS) ClusterCreate(deployments []Resource) error {
+	for _, deployment := range deployments {
+		req, err := decode(deployment)
+		if err != nil {
+			return err
+		}
+		name := *req.Cluster.Name
+
+		log.Printf("Cluster create request: name:'%v', resource group `%s`", name, req.ResourceGroup)
+		desc := fmt.Sprintf("creating cluster %v, file: %v", name, deployment.FileName)
+		poller, err := startOperation(c, desc, false, func() (*runtime.Poller[armcontainerservice.ManagedClustersClientCreateOrUpdateResponse], error) {
+			return c.clientClusters.BeginCreateOrUpdate(c.ctx, req.ResourceGroup, name, req.Cluster, nil)
+		})
+		if err != nil {
+			return err
+		}
+		if err := waitOperation(c, poller, desc); err != nil {
+			return err
+		}
+
+		err = provider.RetryUntilTrue(
+			c.ctx,
+			fmt.Sprintf("creating cluster:%v", name),
+			provider.GlobalRetryTimeout,
+			func() (bool, error) { return c.clusterRunning(req.ResourceGroup, name) })
+		if err != nil {
+			return fmt.Errorf("creating cluster %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ClusterDelete deletes a k8s cluster.
+func (c *AKS) ClusterDelete(deployments []Resource) error {
+	for _, deployment := range deployments {
+		req, err := decode(deployment)
+		if err != nil {
+			return err
+		}
+		name := *req.Cluster.Name
+
+		log.Printf("Removing cluster '%v', resource group '%v'", name, req.ResourceGroup)
+		desc := "deleting cluster " + name
+		poller, err := startOperation(c, desc, true, func() (*runtime.Poller[armcontainerservice.ManagedClustersClientDeleteResponse], error) {
+			return c.clientClusters.BeginDelete(c.ctx, req.ResourceGroup, name, nil)
+		})
+		if err == nil {
+			err = waitOperation(c, poller, desc)
+		}
+		if err != nil {
+			return fmt.Errorf("removing cluster %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// clusterRunning checks whether a cluster has been provisioned.
+func (c *AKS) clusterRunning(resourceGroup, name string) (bool, error) {
+	rep, err := c.clientClusters.Get(c.ctx, resourceGroup, name, nil)
+	if err != nil {
+		// We don't consider none existing cluster error a failure. So don't return an error here.
+		if statusCode(err) == http.StatusNotFound {
+			return false, nil
+		}
+		return false, apiError("getting status of cluster "+name, err)
+	}
+	state := provisioningState(rep.Properties)
+	switch state {
+	case "Succeeded":
+		return true, nil
+	case "Failed", "Canceled", "Deleting":
+		return false, apiError("creating cluster "+name, fmt.Errorf("cluster not in a state to become ready - %s", state))
+	}
+	log.Printf("Cluster '%v' provisioning state: %v", name, state)
+	return false, nil
+}
+
+// startOperation sends a request starting a long-running operation and returns its poller.
+// AKS runs one operation per cluster at a time and answers other requests with 409 Conflict
+// while it runs, so these are sent again until AKS accepts them.
+// With ignoreNotFound a missing resource returns a nil poller, for deleting resources that don't exist.
+func startOperation[T any](c *AKS, desc string, ignoreNotFound bool, request func() (*runtime.Poller[T], error)) (*runtime.Poller[T], error) {
+	var poller *runtime.Poller[T]
+	err := provider.RetryUntilTrue(
+		c.ctx,
+		desc,
+		provider.GlobalRetryTimeout,
+		func() (bool, error) {
+			var err error
+			poller, err = request()
+			switch {
+			case err == nil:
+				return true, nil
+			case ignoreNotFound && statusCode(err) == http.StatusNotFound:
+				poller = nil
+				return true, nil
+			case statusCode(err) == http.StatusConflict:
+				// Another operation is running on the cluster, wait for it to complete.
+				log.Printf("Cluster in 'Conflict' state '%s'", err)
+				return false, nil
+			}
+			return false, apiError(desc, err)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return poller, nil
+}
+
+// waitOperation polls a long-running operation until it is done and returns its error.
+// A nil poller is done.
+func waitOperation[T any](c *AKS, poller *runtime.Poller[T], desc string) error {
+	if poller == nil {
+		return nil
+	}
+	if _, err := poller.PollUntilDone(c.ctx, &runtime.PollUntilDoneOptions{Frequency: c.pollFrequency}); err != nil {
+		return apiError(desc, err)
+	}
+	return nil
+}
+
+// NodePoolCreate creates the node pools in an existing cluster.
+func (c *AKS) NodePoolCreate(deployments []Resource) error {
+	for _, deployment := range deployments {
+		req, err := decode(deployment)
+		if err != nil {
+			return err
+		}
+		cluster := *req.Cluster.Name
+
+		for _, pool := range req.NodePools {
+			log.Printf("Cluster node pool create request: cluster '%v', node pool '%v', resource group `%s`", cluster, *pool.Name, req.ResourceGroup)
+
+			desc := "creating node pool " + *pool.Name
+			poller, err := startOperation(c, desc, false, func() (*runtime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse], error) {
+				return c.clientNodePools.BeginCreateOrUpdate(c.ctx, req.ResourceGroup, cluster, *pool.Name, pool, nil)
+			})
+			if err == nil {
+				err = waitOperation(c, poller, desc)
+			}
+			if err != nil {
+				return fmt.Errorf("creating cluster node pool %v, file: %v: %w", *pool.Name, deployment.FileName, err)
+			}
+
+			err = provider.RetryUntilTrue(
+				c.ctx,
+				fmt.Sprintf("checking node pool running status for:%v", *pool.Name),
+				provider.GlobalRetryTimeout,
+				func() (bool, error) { return c.nodePoolRunning(req.ResourceGroup, cluster, *pool.Name) })
+			if err != nil {
+				return fmt.Errorf("creating cluster node pool %v, file: %v: %w", *pool.Name, deployment.FileName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NodePoolDelete deletes the node pools in an existing cluster.
+func (c *AKS) NodePoolDelete(deployments []Resource) error {
+	for _, deployment := range deployments {
+		req, err := decode(deployment)
+		if err != nil {
+			return err
+		}
+		cluster := *req.Cluster.Name
+
+		for _, pool := range req.NodePools {
+			log.Printf("Removing cluster node pool: `%v`, cluster '%v', resource group '%v'", *pool.Name, cluster, req.ResourceGroup)
+
+			desc := "deleting node pool " + *pool.Name
+			poller, err := startOperation(c, desc, true, func() (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error) {
+				return c.clientNodePools.BeginDelete(c.ctx, req.ResourceGroup, cluster, *pool.Name, nil)
+			})
+			if err == nil {
+				err = waitOperation(c, poller, desc)
+			}
+			if err != nil {
+				return fmt.Errorf("deleting cluster node pool %v, file: %v: %w", *pool.Name, deployment.FileName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// nodePoolRunning checks whether a node pool has been provisioned.
+func (c *AKS) nodePoolRunning(resourceGroup, cluster, name string) (bool, error) {
+	rep, err := c.clientNodePools.Get(c.ctx, resourceGroup, cluster, name, nil)
+	if err != nil {
+		// We don't consider none existing cluster node pool a failure. So don't return an error here.
+		if statusCode(err) == http.StatusNotFound {
+			return false, nil
+		}
+		return false, apiError("getting status of node pool "+name, err)
+	}
+	state := nodePoolState(rep.Properties)
+	switch state {
+	case "Succeeded":
+		return true, nil
+	case "Failed", "Canceled", "Deleting":
+		return false, apiError("creating node pool "+name, fmt.Errorf("node pool not in a state to become ready - %s", state))
+	}
+	log.Printf("Current cluster node pool '%v' provisioning state: %v", name, state)
+	return false, nil
+}
+
+// NodePoolsRunning returns an error if at least one node pool is not running.
+func (c *AKS) NodePoolsRunning(deployments []Resource) error {
+	for _, deployment := range deployments {
+		req, err := decode(deployment)
+		if err != nil {
+			return err
+		}
+		for _, pool := range req.NodePools {
+			isRunning, err := c.nodePoolRunning(req.ResourceGroup, *req.Cluster.Name, *pool.Name)
+			if err != nil {
+				return fmt.Errorf("error fetching node pool info: %w", err)
+			}
+			if !isRunning {
+				return fmt.Errorf("node pool not running name: %v", *pool.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// NodePoolsDeleted returns an error if at least one node pool is not deleted.
+func (c *AKS) NodePoolsDeleted(deployments []Resource) error {
+	for _, deployment := range deployments {
+		req, err := decode(deployment)
+		if err != nil {
+			return err
+		}
+		for _, pool := range req.NodePools {
+			_, err := c.clientNodePools.Get(c.ctx, req.ResourceGroup, *req.Cluster.Name, *pool.Name, nil)
+			if statusCode(err) == http.StatusNotFound {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error fetching node pool info: %w", apiError("getting status of node pool "+*pool.Name, err))
+			}
+			return fmt.Errorf("node pool running name: %v", *pool.Name)
+		}
+	}
+	return nil
+}
+
+// KubeConfig returns the kubeconfig of the cluster in the cluster file retrieved from AKS.
+// It has the cluster user credentials, or the cluster admin credentials with Admin set.
+func (c *AKS) KubeConfig() (*clientcmdapi.Config, error) {
+	if c.ClusterFile == "" {
+		return nil, errors.New("missing --cluster-file to read the resource group and the name of the cluster from")
+	}
+	deployments, err := provider.DeploymentsParse([]string{c.ClusterFile}, c.DeploymentVars)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the cluster file: %w", err)
+	}
+	if len(deployments) != 1 {
+		return nil, fmt.Errorf("cluster file %v is not a single file", c.ClusterFile)
+	}
+	req, err := decode(deployments[0])
+	if err != nil {
+		return nil, err
+	}
+	name := *req.Cluster.Name
+
+	var creds armcontainerservice.CredentialResults
+	if c.Admin {
+		rep, err := c.clientClusters.ListClusterAdminCredentials(c.ctx, req.ResourceGroup, name, nil)
+		if err != nil {
+			return nil, apiError("getting cluster admin credentials of "+name, err)
+		}
+		creds = rep.CredentialResults
+	} else {
+		rep, err := c.clientClusters.ListClusterUserCredentials(c.ctx, req.ResourceGroup, name, nil)
+		if err != nil {
+			return nil, apiError("getting cluster user credentials of "+name, err)
+		}
+		creds = rep.CredentialResults
+	}
+	if len(creds.Kubeconfigs) == 0 {
+		return nil, apiError("getting cluster credentials of "+name, errors.New("no kubeconfig returned"))
+	}
+	config, err := clientcmd.Load(creds.Kubeconfigs[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the kubeconfig of cluster %v: %w", name, err)
+	}
+	return config, nil
+}
+
+func provisioningState(p *armcontainerservice.ManagedClusterProperties) string {
+	if p == nil || p.ProvisioningState == nil {
+		return ""
+	}
+	return *p.ProvisioningState
+}
+
+func nodePoolState(p *armcontainerservice.ManagedClusterAgentPoolProfileProperties) string {
+	if p == nil || p.ProvisioningState == nil {
+		return ""
+	}
+	return *p.ProvisioningState
+}
@@ -0,0 +1,335 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aks
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+// fakeARM is an in-process ARM API serving the managed cluster and agent pool requests of the AKS provider.
+// Resources are "Creating" until they are read once, and deleted resources are "Deleting" until the result
+// of the delete operation is read once, so that the provider has to poll the long-running operations.
+type fakeARM struct {
+	mtx sync.Mutex
+	// resources maps the resource paths to their provisioning state.
+	resources map[string]string
+	// failing resources end up in the Failed state.
+	failing map[string]bool
+	// conflicts is the number of create and delete requests answered with 409 Conflict,
+	// as AKS does while another operation runs on the cluster.
+	conflicts int
+	// deletes is the number of accepted delete requests.
+	deletes int
+}
+
+func (f *fakeARM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	path := strings.ToLower(r.URL.Path)
+	if strings.HasPrefix(path, "/operationresults/") {
+		// The delete operation is in progress until its result is read once.
+		resource := strings.TrimPrefix(path, "/operationresults")
+		if _, ok := f.resources[resource]; ok {
+			delete(f.resources, resource)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for _, kind := range []string{"admin", "user"} {
+		suffix := "/listcluster" + kind + "credential"
+		if !strings.HasSuffix(path, suffix) {
+			continue
+		}
+		if _, ok := f.resources[strings.TrimSuffix(path, suffix)]; !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFound")
+			return
+		}
+		// The context is named after the kind of the credentials.
+		kubeconfig := "apiVersion: v1\nkind: Config\nclusters:\n- name: c\n  cluster: {server: https://aks.example.com}\ncontexts:\n- name: " + kind + "\n  context: {cluster: c, user: u}\ncurrent-context: " + kind + "\nusers:\n- name: u\n  user: {token: t}\n"
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kubeconfigs": []map[string]string{{"name": "cluster" + kind, "value": base64.StdEncoding.EncodeToString([]byte(kubeconfig))}},
+		})
+		return
+	}
+
+	if (r.Method == http.MethodPut || r.Method == http.MethodDelete) && f.conflicts > 0 {
+		f.conflicts--
+		writeError(w, http.StatusConflict, "OperationNotAllowed")
+		return
+	}
+
+	state, ok := f.resources[path]
+	switch r.Method {
+	case http.MethodPut:
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequestContent")
+			return
+		}
+		f.resources[path] = "Creating"
+		writeJSON(w, http.StatusCreated, resource(path, "Creating"))
+	case http.MethodGet:
+		if !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFound")
+			return
+		}
+		switch {
+		case state == "Creating" && f.failing[path]:
+			f.resources[path] = "Failed"
+		case state == "Creating":
+			f.resources[path] = "Succeeded"
+		}
+		writeJSON(w, http.StatusOK, resource(path, state))
+	case http.MethodDelete:
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f.resources[path] = "Deleting"
+		f.deletes++
+		w.Header().Set("Location", "https://"+r.Host+"/operationresults"+path)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func resource(path, state string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         path,
+		"name":       path[strings.LastIndex(path, "/")+1:],
+		"properties": map[string]string{"provisioningState": state},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	writeJSON(w, status, map[string]interface{}{"error": map[string]string{"code": code, "message": code}})
+}
+
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "fake", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// newFakeAKS returns an AKS provider talking to a fake ARM API.
+func newFakeAKS(t *testing.T) (*AKS, *fakeARM) {
+	t.Helper()
+	t.Cleanup(provider.SetRetryBackoff(time.Millisecond, time.Millisecond))
+
+	f := &fakeARM{resources: map[string]string{}, failing: map[string]bool{}}
+	srv := httptest.NewTLSServer(f)
+	t.Cleanup(srv.Close)
+
+	c := New(context.Background())
+	c.credential = fakeCredential{}
+	c.pollFrequency = time.Millisecond
+	c.clientOptions = &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Cloud: cloud.Configuration{
+				ActiveDirectoryAuthorityHost: srv.URL,
+				Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+					cloud.ResourceManager: {Audience: "https://management.azure.com", Endpoint: srv.URL},
+				},
+			},
+			Transport: srv.Client(),
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+		DisableRPRegistration: true,
+	}
+	if err := c.Init(map[string]string{"AKS_SUBSCRIPTION_ID": "sub", "AKS_RESOURCE_GROUP": "rg"}); err != nil {
+		t.Fatal(err)
+	}
+	return c, f
+}
+
+const clusterPath = "/subscriptions/sub/resourcegroups/rg/providers/microsoft.containerservice/managedclusters/prombench"
+
+var (
+	clusterFile = Resource{FileName: "cluster_aks.yaml", Content: []byte(`
+resourcegroup: rg
+cluster:
+  name: prombench
+  location: westeurope
+  properties:
+    dnsPrefix: prombench
+    agentPoolProfiles:
+    - name: main
+      mode: System
+      count: 1
+      vmSize: Standard_D4s_v5
+`)}
+	nodesFile = Resource{FileName: "nodes_aks.yaml", Content: []byte(`
+resourcegroup: rg
+cluster:
+  name: prombench
+nodepools:
+- name: prom123
+  properties:
+    count: 2
+    vmSize: Standard_E8s_v5
+- name: nodes123
+  properties:
+    count: 1
+    vmSize: Standard_F16s_v2
+`)}
+)
+
+func TestCluster(t *testing.T) {
+	c, f := newFakeAKS(t)
+
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	if state := f.resources[clusterPath]; state != "Succeeded" {
+		t.Fatalf("expected a running cluster, got state %q", state)
+	}
+
+	// The cluster is read from the templated cluster file.
+	if _, err := c.KubeConfig(); err == nil {
+		t.Fatal("expected an error without a cluster file")
+	}
+	c.ClusterFile = filepath.Join(t.TempDir(), "cluster_aks.yaml")
+	if err := os.WriteFile(c.ClusterFile, []byte("resourcegroup: {{ .AKS_RESOURCE_GROUP }}\ncluster:\n  name: prombench\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		admin   bool
+		context string
+	}{{false, "user"}, {true, "admin"}} {
+		c.Admin = tc.admin
+		config, err := c.KubeConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.CurrentContext != tc.context || config.Clusters["c"].Server != "https://aks.example.com" {
+			t.Errorf("expected the %v credentials, got kubeconfig %+v", tc.context, config)
+		}
+	}
+
+	f.conflicts = 2
+	if err := c.ClusterDelete([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.resources[clusterPath]; ok {
+		t.Fatal("expected the cluster to be deleted")
+	}
+	// The delete operation is polled instead of sending the request again.
+	if f.deletes != 1 {
+		t.Fatalf("expected a single accepted delete request, got %d", f.deletes)
+	}
+	// Deleting a cluster that doesn't exist succeeds.
+	if err := c.ClusterDelete([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterFailed(t *testing.T) {
+	c, f := newFakeAKS(t)
+	f.failing[clusterPath] = true
+
+	err := c.ClusterCreate([]Resource{clusterFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || !strings.Contains(err.Error(), "Failed") {
+		t.Fatalf("expected a CloudAPIError for the failed cluster, got %v", err)
+	}
+}
+
+func TestNodePoolFailed(t *testing.T) {
+	c, f := newFakeAKS(t)
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	f.failing[clusterPath+"/agentpools/nodes123"] = true
+
+	// The failure of the create operation is returned without waiting for the node pool.
+	err := c.NodePoolCreate([]Resource{nodesFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || cloudAPIErr.Op != "creating node pool nodes123" || !strings.Contains(err.Error(), "Failed") {
+		t.Fatalf("expected a CloudAPIError for the failed node pool operation, got %v", err)
+	}
+}
+
+func TestNodePools(t *testing.T) {
+	c, f := newFakeAKS(t)
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	f.conflicts = 1
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for running node pools")
+	}
+
+	if err := c.NodePoolDelete([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for deleted node pools")
+	}
+	if len(f.resources) != 1 {
+		t.Errorf("expected only the cluster to be left, got %v", f.resources)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	c, _ := newFakeAKS(t)
+	for _, content := range []string{"resourcegroup: rg\ncluster:\n  name: prombench\nunknown: 1\n", "cluster:\n  name: prombench\n"} {
+		err := c.ClusterCreate([]Resource{{FileName: "cluster_aks.yaml", Content: []byte(content)}})
+		var decodeErr *provider.DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%q: expected a DecodeError, got %v", content, err)
+		}
+	}
+}
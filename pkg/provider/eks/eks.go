@@ -21,7 +21,6 @@ import (
 	"log"
 	"os"
 	"regexp"
-	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
@@ -30,17 +29,37 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/eks/types"
 	"gopkg.in/alecthomas/kingpin.v2"
 	yamlGo "gopkg.in/yaml.v2"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes/scheme"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	awsToken "sigs.k8s.io/aws-iam-authenticator/pkg/token"
 
 	"github.com/prometheus/test-infra/pkg/provider"
-	k8sProvider "github.com/prometheus/test-infra/pkg/provider/k8s"
 )
 
+// Resource is a parsed EKS deployment file, an alias of provider.Resource.
 type Resource = provider.Resource
 
+func init() {
+	provider.Register(provider.Registration{
+		Name:         "eks",
+		Help:         "Amazon Elastic Kubernetes Service - https://aws.amazon.com/eks",
+		RequiredVars: []string{"ZONE", "CLUSTER_NAME"},
+		New:          func(ctx context.Context) provider.Provider { return New(ctx) },
+	})
+}
+
+// apiError wraps the error of a failed EKS API request.
+func apiError(op string, err error) error {
+	return &provider.CloudAPIError{Provider: "eks", Op: op, Err: err}
+}
+
+// notFound returns whether a failed EKS API request didn't find the cluster or nodegroup.
+// EKS answers the describe requests of missing resources with a ResourceNotFoundException.
+func notFound(err error) bool {
+	var nfe *types.NotFoundException
+	var rnfe *types.ResourceNotFoundException
+	return errors.As(err, &nfe) || errors.As(err, &rnfe)
+}
+
 type eksCluster struct {
 	Cluster    eks.CreateClusterInput
 	NodeGroups []eks.CreateNodegroupInput
@@ -60,34 +79,37 @@ type EKS struct {
 	ClusterName string
 	// The eks client used when performing EKS requests.
 	clientEKS *eks.Client
+	// clientOptions are set by the tests to use a fake EKS API.
+	clientOptions []func(*eks.Options)
 	// The aws config used for AWS API calls.
 	awsCfg aws.Config
-	// The k8s provider used when we work with the manifest files.
-	k8sProvider *k8sProvider.K8s
-	// Final DeploymentFiles files.
-	DeploymentFiles []string
 	// Final DeploymentVars.
 	DeploymentVars map[string]string
-	// DeployResource to construct DeploymentVars and DeploymentFiles
-	DeploymentResource *provider.DeploymentResource
-	// Content bytes after parsing the template variables, grouped by filename.
-	eksResources []Resource
-	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
-	k8sResources []k8sProvider.Resource
 
 	ctx context.Context
 }
 
 // New is the EKS constructor
-func New(dr *provider.DeploymentResource) *EKS {
+// All API requests and waits are aborted when ctx is cancelled.
+func New(ctx context.Context) *EKS {
 	eks := &EKS{
-		DeploymentResource: dr,
+		ctx: ctx,
 	}
 	return eks
 }
 
-// NewEKSClient sets the EKS client used when performing the GKE requests.
-func (c *EKS) NewEKSClient(*kingpin.ParseContext) error {
+// Flags registers the auth flag.
+func (c *EKS) Flags(cmd *kingpin.CmdClause) {
+	cmd.Flag("auth", "filename which consist eks credentials.").
+		PlaceHolder("credentials").
+		Short('a').
+		StringVar(&c.Auth)
+}
+
+// Init sets the EKS client used when performing the EKS requests.
+func (c *EKS) Init(deploymentVars map[string]string) error {
+	c.DeploymentVars = deploymentVars
+
 	if c.Auth != "" {
 	} else if c.Auth = os.Getenv("AWS_APPLICATION_CREDENTIALS"); c.Auth == "" {
 		return fmt.Errorf("no auth provided set the auth flag or the AWS_APPLICATION_CREDENTIALS env variable")
@@ -126,8 +148,6 @@ func (c *EKS) NewEKSClient(*kingpin.ParseContext) error {
 	}
 	os.Setenv("AWS_DEFAULT_REGION", c.DeploymentVars["ZONE"])
 
-	c.ctx = context.Background()
-
 	cfg, err := awsConfig.LoadDefaultConfig(c.ctx,
 		awsConfig.WithRegion(c.DeploymentVars["ZONE"]),
 		awsConfig.WithCredentialsProvider(
@@ -143,105 +163,28 @@ func (c *EKS) NewEKSClient(*kingpin.ParseContext) error {
 	}
 
 	c.awsCfg = cfg
-	c.clientEKS = eks.NewFromConfig(cfg)
-	return nil
-}
-
-// checkDeploymentVarsAndFiles checks whether the requied deployment vars are passed.
-func (c *EKS) checkDeploymentVarsAndFiles() error {
-	reqDepVars := []string{"ZONE", "CLUSTER_NAME"}
-	for _, k := range reqDepVars {
-		if v := c.DeploymentVars[k]; v == "" {
-			return fmt.Errorf("missing required %v variable", k)
-		}
-	}
-	if len(c.DeploymentFiles) == 0 {
-		return fmt.Errorf("missing deployment file(s)")
-	}
-	return nil
-}
-
-// SetupDeploymentResources Sets up DeploymentVars and DeploymentFiles
-func (c *EKS) SetupDeploymentResources(*kingpin.ParseContext) error {
-	c.DeploymentFiles = c.DeploymentResource.DeploymentFiles
-	c.DeploymentVars = provider.MergeDeploymentVars(
-		c.DeploymentResource.DefaultDeploymentVars,
-		c.DeploymentResource.FlagDeploymentVars,
-	)
-	return nil
-}
-
-// EKSDeploymentParse parses the cluster/nodegroups deployment file and saves the result as bytes grouped by the filename.
-// Any variables passed to the cli will be replaced in the resource files following the golang text template format.
-func (c *EKS) EKSDeploymentParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
-
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		return fmt.Errorf("Couldn't parse deployment files: %w", err)
-	}
-
-	c.eksResources = deploymentResource
-	return nil
-}
-
-// K8SDeploymentsParse parses the k8s objects deployment files and saves the result as k8s objects grouped by the filename.
-// Any variables passed to the cli will be replaced in the resources files following the golang text template format.
-func (c *EKS) K8SDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
-
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		return fmt.Errorf("Couldn't parse deployment files: %w", err)
-	}
-
-	for _, deployment := range deploymentResource {
-		decode := scheme.Codecs.UniversalDeserializer().Decode
-		k8sObjects := make([]runtime.Object, 0)
-
-		for _, text := range strings.Split(string(deployment.Content), provider.Separator) {
-			text = strings.TrimSpace(text)
-			if len(text) == 0 {
-				continue
-			}
-
-			resource, _, err := decode([]byte(text), nil, nil)
-			if err != nil {
-				return fmt.Errorf("decoding the resource file:%v, section:%v...: %w", deployment.FileName, text[:100], err)
-			}
-			if resource == nil {
-				continue
-			}
-			k8sObjects = append(k8sObjects, resource)
-		}
-		if len(k8sObjects) > 0 {
-			c.k8sResources = append(c.k8sResources, k8sProvider.Resource{FileName: deployment.FileName, Objects: k8sObjects})
-		}
-	}
+	c.clientEKS = eks.NewFromConfig(cfg, c.clientOptions...)
 	return nil
 }
 
 // ClusterCreate create a new cluster or applies changes to an existing cluster.
-func (c *EKS) ClusterCreate(*kingpin.ParseContext) error {
+func (c *EKS) ClusterCreate(deployments []Resource) error {
 	req := &eksCluster{}
-	for _, deployment := range c.eksResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			return fmt.Errorf("Error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		log.Printf("Cluster create request: name:'%s'", *req.Cluster.Name)
 		_, err := c.clientEKS.CreateCluster(c.ctx, &req.Cluster)
 		if err != nil {
-			return fmt.Errorf("Couldn't create cluster '%v', file:%v ,err: %w", *req.Cluster.Name, deployment.FileName, err)
+			return apiError(fmt.Sprintf("creating cluster %v, file: %v", *req.Cluster.Name, deployment.FileName), err)
 		}
 
 		err = provider.RetryUntilTrue(
+			c.ctx,
 			fmt.Sprintf("creating cluster:%v", *req.Cluster.Name),
-			provider.EKSRetryCount,
+			provider.EKSRetryTimeout,
 			func() (bool, error) { return c.clusterRunning(*req.Cluster.Name) },
 		)
 		if err != nil {
@@ -253,12 +196,13 @@ func (c *EKS) ClusterCreate(*kingpin.ParseContext) error {
 			log.Printf("Nodegroup create request: NodeGroupName: '%s', ClusterName: '%s'", *nodegroupReq.NodegroupName, *req.Cluster.Name)
 			_, err := c.clientEKS.CreateNodegroup(c.ctx, &nodegroupReq)
 			if err != nil {
-				return fmt.Errorf("Couldn't create nodegroup '%v' for cluster '%v, file:%v ,err: %w", nodegroupReq.NodegroupName, req.Cluster.Name, deployment.FileName, err)
+				return apiError(fmt.Sprintf("creating nodegroup %v for cluster %v, file: %v", *nodegroupReq.NodegroupName, *req.Cluster.Name, deployment.FileName), err)
 			}
 
 			err = provider.RetryUntilTrue(
+				c.ctx,
 				fmt.Sprintf("creating nodegroup:%s for cluster:%s", *nodegroupReq.NodegroupName, *req.Cluster.Name),
-				provider.EKSRetryCount,
+				provider.EKSRetryTimeout,
 				func() (bool, error) { return c.nodeGroupCreated(*nodegroupReq.NodegroupName, *req.Cluster.Name) },
 			)
 			if err != nil {
@@ -270,11 +214,11 @@ func (c *EKS) ClusterCreate(*kingpin.ParseContext) error {
 }
 
 // ClusterDelete deletes a eks Cluster
-func (c *EKS) ClusterDelete(*kingpin.ParseContext) error {
+func (c *EKS) ClusterDelete(deployments []Resource) error {
 	req := &eksCluster{}
-	for _, deployment := range c.eksResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			return fmt.Errorf("Error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		// To delete a cluster we have to manually delete all cluster
@@ -288,7 +232,7 @@ func (c *EKS) ClusterDelete(*kingpin.ParseContext) error {
 		for {
 			resL, err := c.clientEKS.ListNodegroups(c.ctx, reqL)
 			if err != nil {
-				return fmt.Errorf("listing nodepools err: %w", err)
+				return apiError("listing nodegroups of cluster "+*req.Cluster.Name, err)
 			}
 
 			for _, nodegroup := range resL.Nodegroups {
@@ -300,12 +244,13 @@ func (c *EKS) ClusterDelete(*kingpin.ParseContext) error {
 				}
 				_, err := c.clientEKS.DeleteNodegroup(c.ctx, &reqD)
 				if err != nil {
-					return fmt.Errorf("Couldn't create nodegroup '%v' for cluster '%v ,err: %w", nodegroup, req.Cluster.Name, err)
+					return apiError(fmt.Sprintf("deleting nodegroup %v for cluster %v", nodegroup, *req.Cluster.Name), err)
 				}
 
 				err = provider.RetryUntilTrue(
+					c.ctx,
 					fmt.Sprintf("deleting nodegroup:%v for cluster:%v", nodegroup, *req.Cluster.Name),
-					provider.GlobalRetryCount,
+					provider.GlobalRetryTimeout,
 					func() (bool, error) { return c.nodeGroupDeleted(nodegroup, *req.Cluster.Name) },
 				)
 				if err != nil {
@@ -326,12 +271,13 @@ func (c *EKS) ClusterDelete(*kingpin.ParseContext) error {
 		log.Printf("Removing cluster '%v'", *reqD.Name)
 		_, err := c.clientEKS.DeleteCluster(c.ctx, reqD)
 		if err != nil {
-			return fmt.Errorf("Couldn't delete cluster '%v', file:%v ,err: %w", *req.Cluster.Name, deployment.FileName, err)
+			return apiError(fmt.Sprintf("deleting cluster %v, file: %v", *req.Cluster.Name, deployment.FileName), err)
 		}
 
 		err = provider.RetryUntilTrue(
+			c.ctx,
 			fmt.Sprintf("deleting cluster:%v", *reqD.Name),
-			provider.GlobalRetryCount,
+			provider.GlobalRetryTimeout,
 			func() (bool, error) { return c.clusterDeleted(*reqD.Name) })
 		if err != nil {
 			return fmt.Errorf("removing cluster err: %w", err)
@@ -347,14 +293,13 @@ func (c *EKS) clusterRunning(name string) (bool, error) {
 	}
 	clusterRes, err := c.clientEKS.DescribeCluster(c.ctx, req)
 	if err != nil {
-		var nfe *types.NotFoundException
-		if errors.As(err, &nfe) {
+		if notFound(err) {
 			return false, nil
 		}
-		return false, fmt.Errorf("Couldn't get cluster status: %w", err)
+		return false, apiError("getting status of cluster "+name, err)
 	}
 	if clusterRes.Cluster.Status == types.ClusterStatusFailed {
-		return false, fmt.Errorf("Cluster not in a status to become ready - %s", clusterRes.Cluster.Status)
+		return false, apiError("creating cluster "+name, fmt.Errorf("cluster not in a status to become ready - %s", clusterRes.Cluster.Status))
 	}
 	if clusterRes.Cluster.Status == types.ClusterStatusActive {
 		return true, nil
@@ -369,23 +314,22 @@ func (c *EKS) clusterDeleted(name string) (bool, error) {
 	}
 	clusterRes, err := c.clientEKS.DescribeCluster(c.ctx, req)
 	if err != nil {
-		var rnfe *types.ResourceNotFoundException
-		if errors.As(err, &rnfe) {
+		if notFound(err) {
 			return true, nil
 		}
-		return false, fmt.Errorf("Couldn't get cluster status: %w", err)
+		return false, apiError("getting status of cluster "+name, err)
 	}
 
 	log.Printf("Cluster '%v' status: %v", name, clusterRes.Cluster.Status)
 	return false, nil
 }
 
-// NodeGroupCreate creates a new k8s nodegroup in an existing cluster.
-func (c *EKS) NodeGroupCreate(*kingpin.ParseContext) error {
+// NodePoolCreate creates a new k8s nodegroup in an existing cluster.
+func (c *EKS) NodePoolCreate(deployments []Resource) error {
 	req := &eksCluster{}
-	for _, deployment := range c.eksResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			return fmt.Errorf("Error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		for _, nodegroupReq := range req.NodeGroups {
@@ -393,12 +337,13 @@ func (c *EKS) NodeGroupCreate(*kingpin.ParseContext) error {
 			log.Printf("Nodegroup create request: NodeGroupName: '%s', ClusterName: '%s'", *nodegroupReq.NodegroupName, *req.Cluster.Name)
 			_, err := c.clientEKS.CreateNodegroup(c.ctx, &nodegroupReq)
 			if err != nil {
-				return fmt.Errorf("Couldn't create nodegroup '%s' for cluster '%s', file:%v ,err: %w", *nodegroupReq.NodegroupName, *req.Cluster.Name, deployment.FileName, err)
+				return apiError(fmt.Sprintf("creating nodegroup %v for cluster %v, file: %v", *nodegroupReq.NodegroupName, *req.Cluster.Name, deployment.FileName), err)
 			}
 
 			err = provider.RetryUntilTrue(
+				c.ctx,
 				fmt.Sprintf("creating nodegroup:%s for cluster:%s", *nodegroupReq.NodegroupName, *req.Cluster.Name),
-				provider.GlobalRetryCount,
+				provider.GlobalRetryTimeout,
 				func() (bool, error) { return c.nodeGroupCreated(*nodegroupReq.NodegroupName, *req.Cluster.Name) },
 			)
 			if err != nil {
@@ -409,12 +354,12 @@ func (c *EKS) NodeGroupCreate(*kingpin.ParseContext) error {
 	return nil
 }
 
-// NodeGroupDelete deletes a k8s nodegroup in an existing cluster
-func (c *EKS) NodeGroupDelete(*kingpin.ParseContext) error {
+// NodePoolDelete deletes a k8s nodegroup in an existing cluster
+func (c *EKS) NodePoolDelete(deployments []Resource) error {
 	req := &eksCluster{}
-	for _, deployment := range c.eksResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			return fmt.Errorf("Error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		for _, nodegroupReq := range req.NodeGroups {
@@ -426,11 +371,12 @@ func (c *EKS) NodeGroupDelete(*kingpin.ParseContext) error {
 			}
 			_, err := c.clientEKS.DeleteNodegroup(c.ctx, &reqD)
 			if err != nil {
-				return fmt.Errorf("Couldn't delete nodegroup '%s' for cluster '%s, file:%v ,err: %w", *nodegroupReq.NodegroupName, *req.Cluster.Name, deployment.FileName, err)
+				return apiError(fmt.Sprintf("deleting nodegroup %v for cluster %v, file: %v", *nodegroupReq.NodegroupName, *req.Cluster.Name, deployment.FileName), err)
 			}
 			err = provider.RetryUntilTrue(
+				c.ctx,
 				fmt.Sprintf("deleting nodegroup:%s for cluster:%s", *nodegroupReq.NodegroupName, *req.Cluster.Name),
-				provider.GlobalRetryCount,
+				provider.GlobalRetryTimeout,
 				func() (bool, error) { return c.nodeGroupDeleted(*nodegroupReq.NodegroupName, *req.Cluster.Name) },
 			)
 			if err != nil {
@@ -448,11 +394,10 @@ func (c *EKS) nodeGroupCreated(nodegroupName, clusterName string) (bool, error)
 	}
 	nodegroupRes, err := c.clientEKS.DescribeNodegroup(c.ctx, req)
 	if err != nil {
-		var nfe *types.NotFoundException
-		if errors.As(err, &nfe) {
+		if notFound(err) {
 			return false, nil
 		}
-		return false, fmt.Errorf("Couldn't get nodegroupname status: %w", err)
+		return false, apiError(fmt.Sprintf("getting status of nodegroup %v for cluster %v", nodegroupName, clusterName), err)
 	}
 	if nodegroupRes.Nodegroup.Status == types.NodegroupStatusActive {
 		return true, nil
@@ -469,28 +414,27 @@ func (c *EKS) nodeGroupDeleted(nodegroupName, clusterName string) (bool, error)
 	}
 	nodegroupRes, err := c.clientEKS.DescribeNodegroup(c.ctx, req)
 	if err != nil {
-		var rnfe *types.ResourceNotFoundException
-		if errors.As(err, &rnfe) {
+		if notFound(err) {
 			return true, nil
 		}
-		return false, fmt.Errorf("Couldn't get nodegroupname status: %w", err)
+		return false, apiError(fmt.Sprintf("getting status of nodegroup %v for cluster %v", nodegroupName, clusterName), err)
 	}
 
 	log.Printf("Nodegroup '%v' for Cluster '%v' status: %v", nodegroupName, clusterName, nodegroupRes.Nodegroup.Status)
 	return false, nil
 }
 
-// AllNodeGroupsRunning returns an error if at least one node pool is not running
-func (c *EKS) AllNodeGroupsRunning(*kingpin.ParseContext) error {
+// NodePoolsRunning returns an error if at least one node pool is not running
+func (c *EKS) NodePoolsRunning(deployments []Resource) error {
 	req := &eksCluster{}
-	for _, deployment := range c.eksResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			return fmt.Errorf("Error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 		for _, nodegroup := range req.NodeGroups {
 			isRunning, err := c.nodeGroupCreated(*nodegroup.NodegroupName, *req.Cluster.Name)
 			if err != nil {
-				return fmt.Errorf("error fetching nodegroup info")
+				return fmt.Errorf("error fetching nodegroup info: %w", err)
 			}
 			if !isRunning {
 				return fmt.Errorf("nodepool not running name: %v", *nodegroup.NodegroupName)
@@ -500,17 +444,17 @@ func (c *EKS) AllNodeGroupsRunning(*kingpin.ParseContext) error {
 	return nil
 }
 
-// AllNodeGroupsDeleted returns an error if at least one node pool is not deleted
-func (c *EKS) AllNodeGroupsDeleted(*kingpin.ParseContext) error {
+// NodePoolsDeleted returns an error if at least one node pool is not deleted
+func (c *EKS) NodePoolsDeleted(deployments []Resource) error {
 	req := &eksCluster{}
-	for _, deployment := range c.eksResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			return fmt.Errorf("Error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 		for _, nodegroup := range req.NodeGroups {
 			isRunning, err := c.nodeGroupDeleted(*nodegroup.NodegroupName, *req.Cluster.Name)
 			if err != nil {
-				return fmt.Errorf("error fetching nodegroup info")
+				return fmt.Errorf("error fetching nodegroup info: %w", err)
 			}
 			if !isRunning {
 				return fmt.Errorf("nodepool not running name: %v", *nodegroup.NodegroupName)
@@ -521,10 +465,10 @@ func (c *EKS) AllNodeGroupsDeleted(*kingpin.ParseContext) error {
 }
 
 // EKSK8sToken returns aws iam authenticator token which is used to access eks k8s cluster from outside.
-func (c *EKS) EKSK8sToken(clusterName, _ string) awsToken.Token {
+func (c *EKS) EKSK8sToken(clusterName, _ string) (awsToken.Token, error) {
 	gen, err := awsToken.NewGenerator(true, false)
 	if err != nil {
-		log.Fatalf("Token abstraction error: %v", err)
+		return awsToken.Token{}, fmt.Errorf("token abstraction error: %w", err)
 	}
 
 	opts := &awsToken.GetTokenOptions{
@@ -534,14 +478,14 @@ func (c *EKS) EKSK8sToken(clusterName, _ string) awsToken.Token {
 
 	tok, err := gen.GetWithOptions(c.ctx, opts)
 	if err != nil {
-		log.Fatalf("Token abstraction error: %v", err)
+		return awsToken.Token{}, apiError("getting a token for cluster "+clusterName, err)
 	}
 
-	return tok
+	return tok, nil
 }
 
-// NewK8sProvider sets the k8s provider used for deploying k8s manifests
-func (c *EKS) NewK8sProvider(*kingpin.ParseContext) error {
+// KubeConfig returns the config of the cluster with an aws iam authenticator token.
+func (c *EKS) KubeConfig() (*clientcmdapi.Config, error) {
 	clusterName := c.DeploymentVars["CLUSTER_NAME"]
 	region := c.DeploymentVars["ZONE"]
 
@@ -551,14 +495,14 @@ func (c *EKS) NewK8sProvider(*kingpin.ParseContext) error {
 
 	rep, err := c.clientEKS.DescribeCluster(c.ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster details: %w", err)
+		return nil, apiError("getting cluster details of "+clusterName, err)
 	}
 
 	arnRole := *rep.Cluster.Arn
 
 	caCert, err := base64.StdEncoding.DecodeString(*rep.Cluster.CertificateAuthority.Data)
 	if err != nil {
-		return fmt.Errorf("failed to decode certificate: %w", err)
+		return nil, fmt.Errorf("failed to decode certificate: %w", err)
 	}
 
 	cluster := clientcmdapi.NewCluster()
@@ -569,8 +513,12 @@ func (c *EKS) NewK8sProvider(*kingpin.ParseContext) error {
 	clusterContext.Cluster = arnRole
 	clusterContext.AuthInfo = arnRole
 
+	tok, err := c.EKSK8sToken(clusterName, region)
+	if err != nil {
+		return nil, err
+	}
 	authInfo := clientcmdapi.NewAuthInfo()
-	authInfo.Token = c.EKSK8sToken(clusterName, region).Token
+	authInfo.Token = tok.Token
 
 	config := clientcmdapi.NewConfig()
 	config.AuthInfos[arnRole] = authInfo
@@ -580,36 +528,5 @@ func (c *EKS) NewK8sProvider(*kingpin.ParseContext) error {
 	config.Kind = "Config"
 	config.APIVersion = "v1"
 
-	c.k8sProvider, err = k8sProvider.New(c.ctx, config)
-	if err != nil {
-		return fmt.Errorf("k8s provider error %w", err)
-	}
-
-	return nil
-}
-
-// ResourceApply calls k8s.ResourceApply to apply the k8s objects in the manifest files.
-func (c *EKS) ResourceApply(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceApply(c.k8sResources, true); err != nil {
-		return fmt.Errorf("error while applying a resource err: %w", err)
-	}
-	return nil
-}
-
-// ResourceDelete calls k8s.ResourceDelete to apply the k8s objects in the manifest files.
-func (c *EKS) ResourceDelete(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceDelete(c.k8sResources); err != nil {
-		return fmt.Errorf("error while deleting objects from a manifest file err: %w", err)
-	}
-	return nil
-}
-
-// GetDeploymentVars shows deployment variables.
-func (c *EKS) GetDeploymentVars(*kingpin.ParseContext) error {
-	fmt.Print("-------------------\n   DeploymentVars   \n------------------- \n")
-	for key, value := range c.DeploymentVars {
-		fmt.Println(key, " : ", value)
-	}
-
-	return nil
+	return config, nil
 }
@@ -0,0 +1,317 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+// fakeEKS is an in-process EKS REST API serving the cluster and nodegroup requests of the EKS provider.
+// Clusters and nodegroups are "CREATING" until they are read once and "DELETING" until they are read once,
+// so that the provider has to poll them.
+type fakeEKS struct {
+	mtx sync.Mutex
+	// clusters and nodegroups map the cluster names and the cluster/nodegroup names to their status.
+	clusters   map[string]string
+	nodegroups map[string]string
+	// failing resources end up in the FAILED status.
+	failing map[string]bool
+	// errType, when set, fails all requests with this error type.
+	errType string
+}
+
+func (f *fakeEKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.errType != "" {
+		writeError(w, http.StatusForbidden, f.errType)
+		return
+	}
+
+	// The paths are /clusters, /clusters/{name}, /clusters/{name}/node-groups and /clusters/{name}/node-groups/{nodegroup}.
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		var req struct{ Name string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidParameterException")
+			return
+		}
+		if _, ok := f.clusters[req.Name]; ok {
+			writeError(w, http.StatusConflict, "ResourceInUseException")
+			return
+		}
+		f.clusters[req.Name] = "CREATING"
+		writeJSON(w, map[string]interface{}{"cluster": cluster(req.Name, "CREATING")})
+	case len(parts) == 2:
+		name := parts[1]
+		status, ok := f.clusters[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFoundException")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			f.clusters[name] = "DELETING"
+			writeJSON(w, map[string]interface{}{"cluster": cluster(name, "DELETING")})
+			return
+		}
+		f.clusters[name] = f.next(name, status)
+		if f.clusters[name] == "" {
+			delete(f.clusters, name)
+		}
+		writeJSON(w, map[string]interface{}{"cluster": cluster(name, status)})
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		var req struct{ NodegroupName string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidParameterException")
+			return
+		}
+		name := parts[1] + "/" + req.NodegroupName
+		if _, ok := f.nodegroups[name]; ok {
+			writeError(w, http.StatusConflict, "ResourceInUseException")
+			return
+		}
+		f.nodegroups[name] = "CREATING"
+		writeJSON(w, map[string]interface{}{"nodegroup": nodegroup(parts[1], req.NodegroupName, "CREATING")})
+	case len(parts) == 3:
+		// Answer with one nodegroup per page to make the provider follow the next tokens.
+		var names []string
+		for name := range f.nodegroups {
+			if cluster, nodegroup, _ := strings.Cut(name, "/"); cluster == parts[1] {
+				names = append(names, nodegroup)
+			}
+		}
+		sort.Strings(names)
+		// The next token is the name of the next nodegroup, so that deleting the listed nodegroups doesn't skip any.
+		page := sort.SearchStrings(names, r.URL.Query().Get("nextToken"))
+		rep := map[string]interface{}{"nodegroups": []string{}}
+		if page < len(names) {
+			rep["nodegroups"] = names[page : page+1]
+		}
+		if page+1 < len(names) {
+			rep["nextToken"] = names[page+1]
+		}
+		writeJSON(w, rep)
+	case len(parts) == 4:
+		name := parts[1] + "/" + parts[3]
+		status, ok := f.nodegroups[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFoundException")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			f.nodegroups[name] = "DELETING"
+			writeJSON(w, map[string]interface{}{"nodegroup": nodegroup(parts[1], parts[3], "DELETING")})
+			return
+		}
+		f.nodegroups[name] = f.next(name, status)
+		if f.nodegroups[name] == "" {
+			delete(f.nodegroups, name)
+		}
+		writeJSON(w, map[string]interface{}{"nodegroup": nodegroup(parts[1], parts[3], status)})
+	default:
+		writeError(w, http.StatusNotFound, "UnknownOperationException")
+	}
+}
+
+// next returns the status of a resource after it has been read, or an empty status for a deleted resource.
+func (f *fakeEKS) next(name, status string) string {
+	switch {
+	case status == "DELETING":
+		return ""
+	case status == "CREATING" && f.failing[name]:
+		return "FAILED"
+	case status == "CREATING":
+		return "ACTIVE"
+	}
+	return status
+}
+
+func cluster(name, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                 name,
+		"arn":                  "arn:aws:eks:eu-west-1:123456789012:cluster/" + name,
+		"status":               status,
+		"endpoint":             "https://eks.example.com",
+		"certificateAuthority": map[string]string{"data": "Y2VydA=="},
+	}
+}
+
+func nodegroup(clusterName, name, status string) map[string]interface{} {
+	return map[string]interface{}{"clusterName": clusterName, "nodegroupName": name, "status": status}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, errType string) {
+	w.Header().Set("X-Amzn-Errortype", errType)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": errType})
+}
+
+// newFakeEKS returns an EKS provider talking to a fake EKS API.
+func newFakeEKS(t *testing.T) (*EKS, *fakeEKS) {
+	t.Helper()
+	t.Cleanup(provider.SetRetryBackoff(time.Millisecond, time.Millisecond))
+	// Init exports the credentials for the aws-iam-authenticator.
+	for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_DEFAULT_REGION"} {
+		t.Setenv(k, "")
+	}
+
+	f := &fakeEKS{clusters: map[string]string{}, nodegroups: map[string]string{}, failing: map[string]bool{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	c := New(context.Background())
+	c.Auth = "accesskeyid: id\nsecretaccesskey: secret\n"
+	c.clientOptions = []func(*eks.Options){func(o *eks.Options) {
+		o.BaseEndpoint = aws.String(srv.URL)
+		o.HTTPClient = srv.Client()
+	}}
+	if err := c.Init(map[string]string{"ZONE": "eu-west-1", "CLUSTER_NAME": "prombench"}); err != nil {
+		t.Fatal(err)
+	}
+	return c, f
+}
+
+var (
+	clusterFile = Resource{FileName: "cluster_eks.yaml", Content: []byte(`
+cluster:
+  name: prombench
+  rolearn: arn:aws:iam::123456789012:role/cluster
+  resourcesvpcconfig:
+    subnetids: [subnet-1]
+nodegroups:
+  - nodegroupname: main-node
+    noderole: arn:aws:iam::123456789012:role/worker
+    subnets: [subnet-1]
+`)}
+	nodesFile = Resource{FileName: "nodes_eks.yaml", Content: []byte(`
+cluster:
+  name: prombench
+nodegroups:
+  - nodegroupname: prometheus-123
+    noderole: arn:aws:iam::123456789012:role/worker
+    subnets: [subnet-1]
+  - nodegroupname: nodes-123
+    noderole: arn:aws:iam::123456789012:role/worker
+    subnets: [subnet-1]
+`)}
+)
+
+func TestCluster(t *testing.T) {
+	c, f := newFakeEKS(t)
+
+	// A cluster that doesn't exist yet isn't running, but isn't an error either.
+	if running, err := c.clusterRunning("prombench"); running || err != nil {
+		t.Fatalf("expected a missing cluster to not be running, got %v, %v", running, err)
+	}
+
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	if status := f.clusters["prombench"]; status != "ACTIVE" {
+		t.Fatalf("expected an active cluster, got status %q", status)
+	}
+	if status := f.nodegroups["prombench/main-node"]; status != "ACTIVE" {
+		t.Fatalf("expected an active main nodegroup, got status %q", status)
+	}
+
+	// Deleting the cluster deletes all of its nodegroups first, including the ones not in the file.
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ClusterDelete([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.clusters) != 0 || len(f.nodegroups) != 0 {
+		t.Fatalf("expected the cluster and nodegroups to be deleted, got %v %v", f.clusters, f.nodegroups)
+	}
+	if deleted, err := c.clusterDeleted("prombench"); !deleted || err != nil {
+		t.Fatalf("expected a missing cluster to be deleted, got %v, %v", deleted, err)
+	}
+}
+
+func TestClusterFailed(t *testing.T) {
+	c, f := newFakeEKS(t)
+	f.failing["prombench"] = true
+
+	err := c.ClusterCreate([]Resource{clusterFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || !strings.Contains(err.Error(), "FAILED") {
+		t.Fatalf("expected a CloudAPIError for the failed cluster, got %v", err)
+	}
+}
+
+func TestClusterDeletedError(t *testing.T) {
+	c, f := newFakeEKS(t)
+	f.errType = "AccessDeniedException"
+
+	// Errors other than ResourceNotFoundException stop the retries.
+	_, err := c.clusterDeleted("prombench")
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || !strings.Contains(err.Error(), "AccessDeniedException") {
+		t.Fatalf("expected a CloudAPIError with the AccessDeniedException, got %v", err)
+	}
+}
+
+func TestNodePools(t *testing.T) {
+	c, f := newFakeEKS(t)
+	f.clusters["prombench"] = "ACTIVE"
+
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for running nodegroups")
+	}
+
+	if err := c.NodePoolDelete([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for deleted nodegroups")
+	}
+	if len(f.nodegroups) != 0 {
+		t.Errorf("expected no nodegroups to be left, got %v", f.nodegroups)
+	}
+}
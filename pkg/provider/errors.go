@@ -0,0 +1,71 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import "fmt"
+
+// The provider packages return these errors, wrapped with more context,
+// so that callers can tell the kind of a failure apart with errors.As.
+
+// TemplateError is returned when a deployment file can't be read or its template can't be parsed or executed.
+type TemplateError struct {
+	File string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("couldn't apply template to file %s: %v", e.File, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned when a rendered deployment file isn't a valid k8s object or cloud provider request.
+type DecodeError struct {
+	File string
+	// Document is the position of the yaml document in the file, starting from 1.
+	// It is 0 when the whole file is decoded at once.
+	Document int
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Document == 0 {
+		return fmt.Sprintf("decoding file %s: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("decoding file %s, document %d: %v", e.File, e.Document, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// CloudAPIError is returned when a request to the API of a cloud provider fails
+// or the requested resource ends up in a failed state.
+type CloudAPIError struct {
+	// Provider is the name of the cloud provider, e.g. gke or eks.
+	Provider string
+	// Op describes the failed request, e.g. "creating cluster prombench".
+	Op  string
+	Err error
+}
+
+func (e *CloudAPIError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Provider, e.Op, e.Err)
+}
+
+func (e *CloudAPIError) Unwrap() error {
+	return e.Err
+}
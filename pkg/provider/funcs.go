@@ -0,0 +1,245 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templateFuncs are the functions available to all deployment files, in addition to the text/template builtins.
+// They follow the names and argument order of the Sprig library, so that the piped value is the last argument,
+// but fail the rendering on invalid input instead of silently returning a zero value.
+var templateFuncs = template.FuncMap{
+	// k8s objects can't have dots(.) se we add a custom function to allow normalising the variable values.
+	"normalise": func(t string) string {
+		return strings.ReplaceAll(t, ".", "-")
+	},
+	"split": func(rangeVars, separator string) []string {
+		return strings.Split(rangeVars, separator)
+	},
+
+	// Defaults.
+	"default":  defaultValue,
+	"required": required,
+	"empty":    empty,
+	"coalesce": coalesce,
+	"ternary": func(vt, vf interface{}, v bool) interface{} {
+		if v {
+			return vt
+		}
+		return vf
+	},
+
+	// Strings.
+	"quote": func(v interface{}) string {
+		return strconv.Quote(toString(v))
+	},
+	// squote quotes as a single-quoted YAML string, where a quote is escaped by doubling it.
+	"squote": func(v interface{}) string {
+		return "'" + strings.ReplaceAll(toString(v), "'", "''") + "'"
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"replace":    func(old, replacement, s string) string { return strings.ReplaceAll(s, old, replacement) },
+	"join": func(sep string, v []string) string {
+		return strings.Join(v, sep)
+	},
+	"indent": indent,
+	"nindent": func(spaces int, s string) string {
+		return "\n" + indent(spaces, s)
+	},
+
+	// Encoding.
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"sha256sum": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"toYaml": func(v interface{}) (string, error) {
+		b, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n"), err
+	},
+	"toJson": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+
+	// Conversion and arithmetic, the variables are strings so all numbers are parsed as integers.
+	"int":      toInt,
+	"toString": toString,
+	"add": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) { return a + b, nil })
+	},
+	"sub": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) { return a - b, nil })
+	},
+	"mul": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) { return a * b, nil })
+	},
+	"div": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		})
+	},
+	"mod": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a % b, nil
+		})
+	},
+	"max": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) { return max(a, b), nil })
+	},
+	"min": func(a, b interface{}) (int, error) {
+		return arithmetic(a, b, func(a, b int) (int, error) { return min(a, b), nil })
+	},
+
+	// Environment.
+	"env": os.Getenv,
+}
+
+// lenientFuncs are the functions that handle unset variables themselves, which are rendered as empty strings,
+// so the variables passed to them are optional.
+var lenientFuncs = map[string]bool{
+	"default":  true,
+	"empty":    true,
+	"coalesce": true,
+}
+
+// defaultValue returns the value, or def when the value is empty.
+func defaultValue(def, v interface{}) interface{} {
+	if empty(v) {
+		return def
+	}
+	return v
+}
+
+// required fails the rendering with the message when the value is empty.
+func required(msg string, v interface{}) (interface{}, error) {
+	if empty(v) {
+		return nil, errors.New(msg)
+	}
+	return v, nil
+}
+
+// empty reports whether the value is unset or the zero value of its type.
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	default:
+		return rv.IsZero()
+	}
+}
+
+// coalesce returns the first value that isn't empty.
+func coalesce(v ...interface{}) interface{} {
+	for _, val := range v {
+		if !empty(val) {
+			return val
+		}
+	}
+	return nil
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an integer", v)
+		}
+		return i, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int(rv.Float()), nil
+	}
+	return 0, fmt.Errorf("%v of type %T is not an integer", v, v)
+}
+
+func arithmetic(a, b interface{}, op func(a, b int) (int, error)) (int, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y)
+}
@@ -29,24 +29,37 @@ import (
 	"google.golang.org/grpc/status"
 	"gopkg.in/alecthomas/kingpin.v2"
 	yamlGo "gopkg.in/yaml.v2"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes/scheme"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	_ "k8s.io/cloud-provider-gcp/pkg/clientauthplugin/gcp"
 
 	"github.com/prometheus/test-infra/pkg/provider"
-	k8sProvider "github.com/prometheus/test-infra/pkg/provider/k8s"
 )
 
+func init() {
+	provider.Register(provider.Registration{
+		Name:         "gke",
+		Help:         `Google container engine provider - https://cloud.google.com/kubernetes-engine/`,
+		RequiredVars: []string{"GKE_PROJECT_ID", "ZONE", "CLUSTER_NAME"},
+		New:          func(ctx context.Context) provider.Provider { return New(ctx) },
+	})
+}
+
 // New is the GKE constructor.
-func New(dr *provider.DeploymentResource) *GKE {
+// All API requests and waits are aborted when ctx is cancelled.
+func New(ctx context.Context) *GKE {
 	return &GKE{
-		DeploymentResource: dr,
+		ctx: ctx,
 	}
 }
 
+// Resource is a parsed GKE deployment file, an alias of provider.Resource.
 type Resource = provider.Resource
 
+// apiError wraps the error of a failed GKE API request.
+func apiError(op string, err error) error {
+	return &provider.CloudAPIError{Provider: "gke", Op: op, Err: err}
+}
+
 // GKE holds the fields used to generate an API request.
 type GKE struct {
 	// The auth used to authenticate the cli.
@@ -56,28 +69,49 @@ type GKE struct {
 	ProjectID string
 	// The gke client used when performing GKE requests.
 	clientGKE *gke.ClusterManagerClient
-	// The k8s provider used when we work with the manifest files.
-	k8sProvider *k8sProvider.K8s
-	// Final DeploymentFiles files.
-	DeploymentFiles []string
+	// clientOptions are set by the tests to use a fake ClusterManager API instead of the auth credentials.
+	clientOptions []option.ClientOption
 	// Final DeploymentVars.
 	DeploymentVars map[string]string
-	// DeployResource to construct DeploymentVars and DeploymentFiles
-	DeploymentResource *provider.DeploymentResource
-	// Content bytes after parsing the template variables, grouped by filename.
-	gkeResources []Resource
-	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
-	k8sResources []k8sProvider.Resource
 
 	ctx context.Context
 }
 
-// NewGKEClient sets the GKE client used when performing GKE requests.
-func (c *GKE) NewGKEClient(*kingpin.ParseContext) error {
+// Flags registers the auth flag.
+func (c *GKE) Flags(cmd *kingpin.CmdClause) {
+	cmd.Flag("auth", "json authentication for the project. Accepts a filepath or an env variable that includes tha json data. If not set the tool will use the GOOGLE_APPLICATION_CREDENTIALS env variable (export GOOGLE_APPLICATION_CREDENTIALS=service-account.json). https://cloud.google.com/iam/docs/creating-managing-service-account-keys.").
+		PlaceHolder("service-account.json").
+		Short('a').
+		StringVar(&c.Auth)
+}
+
+// Init sets the GKE client used when performing GKE requests.
+func (c *GKE) Init(deploymentVars map[string]string) error {
+	c.DeploymentVars = deploymentVars
+
+	if c.clientOptions == nil {
+		opts, err := c.newClientOptions()
+		if err != nil {
+			return err
+		}
+		c.clientOptions = opts
+	}
+
+	cl, err := gke.NewClusterManagerClient(c.ctx, c.clientOptions...)
+	if err != nil {
+		return fmt.Errorf("could not create the gke client: %w", err)
+	}
+	c.clientGKE = cl
+
+	return nil
+}
+
+// newClientOptions returns the client options with the service account credentials of the auth flag or env variable.
+func (c *GKE) newClientOptions() ([]option.ClientOption, error) {
 	// Set the auth env variable needed to the gke client.
 	if c.Auth != "" {
 	} else if c.Auth = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); c.Auth == "" {
-		return fmt.Errorf("no auth provided! Need to either set the auth flag or the GOOGLE_APPLICATION_CREDENTIALS env variable")
+		return nil, fmt.Errorf("no auth provided! Need to either set the auth flag or the GOOGLE_APPLICATION_CREDENTIALS env variable")
 	}
 
 	// When the auth variable points to a file
@@ -89,12 +123,12 @@ func (c *GKE) NewGKEClient(*kingpin.ParseContext) error {
 	// Check if auth data is base64 encoded and decode it.
 	encoded, err := regexp.MatchString("^([A-Za-z0-9+/]{4})*([A-Za-z0-9+/]{3}=|[A-Za-z0-9+/]{2}==)?$", c.Auth)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if encoded {
 		auth, err := base64.StdEncoding.DecodeString(c.Auth)
 		if err != nil {
-			return fmt.Errorf("could not decode auth data: %w", err)
+			return nil, fmt.Errorf("could not decode auth data: %w", err)
 		}
 		c.Auth = string(auth)
 	}
@@ -102,11 +136,11 @@ func (c *GKE) NewGKEClient(*kingpin.ParseContext) error {
 	// Create temporary file to store the credentials.
 	saFile, err := os.CreateTemp("", "service-account")
 	if err != nil {
-		return fmt.Errorf("could not create temp file: %w", err)
+		return nil, fmt.Errorf("could not create temp file: %w", err)
 	}
 	defer saFile.Close()
 	if _, err := saFile.Write([]byte(c.Auth)); err != nil {
-		return fmt.Errorf("could not write to temp file: %w", err)
+		return nil, fmt.Errorf("could not write to temp file: %w", err)
 	}
 	// Set the auth env variable needed to the k8s client.
 	// The client looks for this special variable name and it is the only way to set the auth for now.
@@ -114,131 +148,42 @@ func (c *GKE) NewGKEClient(*kingpin.ParseContext) error {
 	// https://github.com/kubernetes/kubernetes/pull/80303
 	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", saFile.Name())
 
-	opts := option.WithAuthCredentialsJSON(option.ServiceAccount, []byte(c.Auth))
-
-	cl, err := gke.NewClusterManagerClient(context.Background(), opts)
-	if err != nil {
-		return fmt.Errorf("could not create the gke client: %w", err)
-	}
-	c.clientGKE = cl
-	c.ctx = context.Background()
-
-	return nil
-}
-
-// SetupDeploymentResources Sets up DeploymentVars and DeploymentFiles
-func (c *GKE) SetupDeploymentResources(*kingpin.ParseContext) error {
-	c.DeploymentFiles = c.DeploymentResource.DeploymentFiles
-	c.DeploymentVars = provider.MergeDeploymentVars(
-		c.DeploymentResource.DefaultDeploymentVars,
-		c.DeploymentResource.FlagDeploymentVars,
-	)
-	return nil
-}
-
-// GKEDeploymentsParse parses the cluster/nodepool deployment files and saves the result as bytes grouped by the filename.
-// Any variables passed to the cli will be replaced in the resources files following the golang text template format.
-func (c *GKE) GKEDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
-
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		log.Fatalf("Couldn't parse deployment files: %v", err)
-	}
-
-	c.gkeResources = deploymentResource
-	return nil
-}
-
-// K8SDeploymentsParse parses the k8s objects deployment files and saves the result as k8s objects grouped by the filename.
-// Any variables passed to the cli will be replaced in the resources files following the golang text template format.
-func (c *GKE) K8SDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
-
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		log.Fatalf("Couldn't parse deployment files: %v", err)
-	}
-
-	for _, deployment := range deploymentResource {
-		decode := scheme.Codecs.UniversalDeserializer().Decode
-		k8sObjects := make([]runtime.Object, 0)
-
-		for _, text := range strings.Split(string(deployment.Content), provider.Separator) {
-			text = strings.TrimSpace(text)
-			if len(text) == 0 {
-				continue
-			}
-
-			resource, _, err := decode([]byte(text), nil, nil)
-			if err != nil {
-				return fmt.Errorf("decoding the resource file:%v, section:%v...: %w", deployment.FileName, text[:100], err)
-			}
-			if resource == nil {
-				continue
-			}
-			k8sObjects = append(k8sObjects, resource)
-		}
-		if len(k8sObjects) > 0 {
-			c.k8sResources = append(c.k8sResources, k8sProvider.Resource{FileName: deployment.FileName, Objects: k8sObjects})
-		}
-	}
-	return nil
-}
-
-// checkDeploymentVarsAndFiles checks whether the requied deployment vars are passed.
-func (c *GKE) checkDeploymentVarsAndFiles() error {
-	reqDepVars := []string{"GKE_PROJECT_ID", "ZONE", "CLUSTER_NAME"}
-	for _, k := range reqDepVars {
-		if v, ok := c.DeploymentVars[k]; !ok || v == "" {
-			return fmt.Errorf("missing required %v variable", k)
-		}
-	}
-	if len(c.DeploymentFiles) == 0 {
-		return fmt.Errorf("missing deployment file(s)")
-	}
-	return nil
+	return []option.ClientOption{option.WithAuthCredentialsJSON(option.ServiceAccount, []byte(c.Auth))}, nil
 }
 
 // ClusterCreate create a new cluster or applies changes to an existing cluster.
-func (c *GKE) ClusterCreate(*kingpin.ParseContext) error {
+func (c *GKE) ClusterCreate(deployments []Resource) error {
 	req := &containerpb.CreateClusterRequest{}
-	for _, deployment := range c.gkeResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, req); err != nil {
-			log.Fatalf("Error parsing the cluster deployment file %s:%v", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 		log.Printf("Cluster create request: name:'%v', project `%s`,zone `%s`", req.Cluster.Name, req.ProjectId, req.Zone)
-		_, err := c.clientGKE.CreateCluster(c.ctx, req)
-		if err != nil {
-			log.Fatalf("Couldn't create cluster '%v', file:%v ,err: %v", req.Cluster.Name, deployment.FileName, err)
-		}
-
-		err = provider.RetryUntilTrue(
-			fmt.Sprintf("creating cluster:%v", req.Cluster.Name),
-			provider.GlobalRetryCount,
+		op, err := c.startOperation("creating cluster "+req.Cluster.Name, false, func() (*containerpb.Operation, error) {
+			return c.clientGKE.CreateCluster(c.ctx, req)
+		})
+		if err == nil {
+			// The cluster is running once its create operation is done without an error.
 			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
-			func() (bool, error) { return c.clusterRunning(req.Zone, req.ProjectId, req.Cluster.Name) })
+			err = c.waitOperation(req.ProjectId, req.Zone, op, "creating cluster "+req.Cluster.Name)
+		}
 		if err != nil {
-			log.Fatalf("creating cluster err:%v", err)
+			return fmt.Errorf("creating cluster %v, file: %v: %w", req.Cluster.Name, deployment.FileName, err)
 		}
 	}
 	return nil
 }
 
 // ClusterDelete deletes a k8s cluster.
-func (c *GKE) ClusterDelete(*kingpin.ParseContext) error {
+func (c *GKE) ClusterDelete(deployments []Resource) error {
 	// Use CreateClusterRequest struct to pass the UnmarshalStrict validation and
 	// than use the result to create the DeleteClusterRequest
 	reqC := &containerpb.CreateClusterRequest{}
-	for _, deployment := range c.gkeResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, reqC); err != nil {
-			log.Fatalf("Error parsing the cluster deployment file %s:%v", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 		reqD := &containerpb.DeleteClusterRequest{
 			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
@@ -252,74 +197,109 @@ func (c *GKE) ClusterDelete(*kingpin.ParseContext) error {
 		log.Printf("Removing cluster '%v', project '%v', zone '%v'", reqD.ClusterId, reqD.ProjectId, reqD.Zone)
 
 		//nolint:staticcheck // SA1019 - Ignore "Do not use.".
-		err := provider.RetryUntilTrue(
-			fmt.Sprintf("deleting cluster:%v", reqD.ClusterId),
-			provider.GlobalRetryCount,
-			func() (bool, error) { return c.clusterDeleted(reqD) })
+		op, err := c.startOperation("deleting cluster "+reqD.ClusterId, true, func() (*containerpb.Operation, error) {
+			return c.clientGKE.DeleteCluster(c.ctx, reqD)
+		})
 		if err != nil {
-			log.Fatalf("removing cluster err:%v", err)
+			return fmt.Errorf("removing cluster %v: %w", reqD.ClusterId, err)
+		}
+		//nolint:staticcheck // SA1019 - Ignore "Do not use.".
+		if err := c.waitOperation(reqD.ProjectId, reqD.Zone, op, "deleting cluster "+reqD.ClusterId); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// clusterDeleted checks whether a cluster has been deleted.
-func (c *GKE) clusterDeleted(req *containerpb.DeleteClusterRequest) (bool, error) {
-	rep, err := c.clientGKE.DeleteCluster(c.ctx, req)
+// startOperation sends a request starting a long-running operation and returns the operation.
+// GKE runs one operation per cluster at a time and fails requests with FailedPrecondition
+// while another one runs, so these are sent again until GKE accepts them.
+// With ignoreNotFound a missing resource returns a nil operation, for deleting resources that don't exist.
+func (c *GKE) startOperation(desc string, ignoreNotFound bool, request func() (*containerpb.Operation, error)) (*containerpb.Operation, error) {
+	var op *containerpb.Operation
+	err := provider.RetryUntilTrue(
+		c.ctx,
+		desc,
+		provider.GlobalRetryTimeout,
+		func() (bool, error) {
+			var err error
+			op, err = request()
+			if err == nil {
+				return true, nil
+			}
+			st, ok := status.FromError(err)
+			if !ok {
+				return false, apiError(desc, err)
+			}
+			if ignoreNotFound && st.Code() == codes.NotFound {
+				op = nil
+				return true, nil
+			}
+			if st.Code() == codes.FailedPrecondition {
+				log.Printf("Cluster in 'FailedPrecondition' state '%s'", err)
+				return false, nil
+			}
+			return false, apiError(desc, err)
+		})
 	if err != nil {
-		st, ok := status.FromError(err)
-		if !ok {
-			return false, fmt.Errorf("unknown reply status error, %w", err)
-		}
-		if st.Code() == codes.NotFound {
-			return true, nil
-		}
-		if st.Code() == codes.FailedPrecondition {
-			log.Printf("Cluster in 'FailedPrecondition' state '%s'", err)
-			return false, nil
-		}
-		//nolint:staticcheck // SA1019 - Ignore "Do not use.".
-		return false, fmt.Errorf("deleting cluster:%v: %w", req.ClusterId, err)
+		return nil, err
 	}
-	log.Printf("cluster status: `%v`", rep.Status)
-	return false, nil
+	return op, nil
 }
 
-// clusterRunning checks whether a cluster is in a running state.
-func (c *GKE) clusterRunning(zone, projectID, clusterID string) (bool, error) {
-	req := &containerpb.GetClusterRequest{
-		ProjectId: projectID,
-		Zone:      zone,
-		ClusterId: clusterID,
-	}
-	cluster, err := c.clientGKE.GetCluster(c.ctx, req)
-	if err != nil {
-		// We don't consider none existing cluster error a failure. So don't return an error here.
-		if st, ok := status.FromError(err); ok && st.Code() == codes.NotFound {
-			return false, nil
-		}
-		return false, fmt.Errorf("Couldn't get cluster status: %w", err)
-	}
-	if cluster.Status == containerpb.Cluster_ERROR ||
-		cluster.Status == containerpb.Cluster_STATUS_UNSPECIFIED ||
-		cluster.Status == containerpb.Cluster_STOPPING {
-		return false, fmt.Errorf("Cluster not in a status to become ready - %s", cluster.Status)
+// waitOperation polls a long-running operation until it is done and returns the error of a failed operation.
+// A nil operation is done.
+func (c *GKE) waitOperation(projectID, zone string, op *containerpb.Operation, desc string) error {
+	if op == nil {
+		return nil
+	}
+	req := &containerpb.GetOperationRequest{
+		ProjectId:   projectID,
+		Zone:        zone,
+		OperationId: op.Name,
+	}
+	return provider.RetryUntilTrue(
+		c.ctx,
+		fmt.Sprintf("waiting for operation %v, %v", op.Name, desc),
+		provider.GlobalRetryTimeout,
+		func() (bool, error) {
+			rep, err := c.clientGKE.GetOperation(c.ctx, req)
+			if err != nil {
+				return false, apiError(fmt.Sprintf("getting operation %v, %v", op.Name, desc), err)
+			}
+			if rep.Status != containerpb.Operation_DONE {
+				log.Printf("Operation '%v' %v: %v %v", rep.Name, rep.OperationType, rep.Status, operationProgress(rep))
+				return false, nil
+			}
+			if rep.Error != nil && rep.Error.Code != int32(codes.OK) {
+				return false, apiError(desc, status.ErrorProto(rep.Error))
+			}
+			// Successful operations can have an informational status message.
+			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
+			log.Printf("Operation '%v' %v: %v %v", rep.Name, rep.OperationType, rep.Status, rep.StatusMessage)
+			return true, nil
+		})
+}
+
+// operationProgress returns the stages GKE reports for a running operation.
+func operationProgress(op *containerpb.Operation) string {
+	var stages []string
+	for _, stage := range op.GetProgress().GetStages() {
+		stages = append(stages, fmt.Sprintf("%v:%v", stage.Name, stage.Status))
 	}
-	if cluster.Status == containerpb.Cluster_RUNNING {
-		return true, nil
+	if op.Detail != "" {
+		stages = append(stages, op.Detail)
 	}
-	//nolint:staticcheck // SA1019 - Ignore "Do not use.".
-	log.Printf("Cluster '%v' status:%v , %v", projectID, cluster.Status, cluster.StatusMessage)
-	return false, nil
+	return strings.Join(stages, ", ")
 }
 
 // NodePoolCreate creates a new k8s node-pool in an existing cluster.
-func (c *GKE) NodePoolCreate(*kingpin.ParseContext) error {
+func (c *GKE) NodePoolCreate(deployments []Resource) error {
 	reqC := &containerpb.CreateClusterRequest{}
 
-	for _, deployment := range c.gkeResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, reqC); err != nil {
-			log.Fatalf("Error parsing the cluster deployment file %s:%v", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		for _, node := range reqC.Cluster.NodePools {
@@ -335,61 +315,41 @@ func (c *GKE) NodePoolCreate(*kingpin.ParseContext) error {
 			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 			log.Printf("Cluster nodepool create request: cluster '%v', nodepool '%v' , project `%s`,zone `%s`", reqN.ClusterId, reqN.NodePool.Name, reqN.ProjectId, reqN.Zone)
 
-			err := provider.RetryUntilTrue(
-				fmt.Sprintf("nodepool creation:%v", reqN.NodePool.Name),
-				provider.GlobalRetryCount,
-				func() (bool, error) {
-					return c.nodePoolCreated(reqN)
-				})
+			op, err := c.startOperation("creating nodepool "+reqN.NodePool.Name, false, func() (*containerpb.Operation, error) {
+				return c.clientGKE.CreateNodePool(c.ctx, reqN)
+			})
+			if err == nil {
+				//nolint:staticcheck // SA1019 - Ignore "Do not use.".
+				err = c.waitOperation(reqN.ProjectId, reqN.Zone, op, "creating nodepool "+reqN.NodePool.Name)
+			}
 			if err != nil {
-				log.Fatalf("Couldn't create cluster nodepool '%v', file:%v ,err: %v", node.Name, deployment.FileName, err)
+				return fmt.Errorf("creating cluster nodepool %v, file: %v: %w", node.Name, deployment.FileName, err)
 			}
 
 			err = provider.RetryUntilTrue(
+				c.ctx,
 				fmt.Sprintf("checking nodepool running status for:%v", reqN.NodePool.Name),
-				provider.GlobalRetryCount,
+				provider.GlobalRetryTimeout,
 				func() (bool, error) {
 					//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 					return c.nodePoolRunning(reqN.Zone, reqN.ProjectId, reqN.ClusterId, reqN.NodePool.Name)
 				})
 			if err != nil {
-				log.Fatalf("Couldn't create cluster nodepool '%v', file:%v ,err: %v", node.Name, deployment.FileName, err)
+				return fmt.Errorf("creating cluster nodepool %v, file: %v: %w", node.Name, deployment.FileName, err)
 			}
 		}
 	}
 	return nil
 }
 
-// nodePoolCreated checks if there is any ongoing NodePool operation on the cluster
-// when creating a NodePool.
-func (c *GKE) nodePoolCreated(req *containerpb.CreateNodePoolRequest) (bool, error) {
-	rep, err := c.clientGKE.CreateNodePool(c.ctx, req)
-	if err != nil {
-		st, ok := status.FromError(err)
-		if !ok {
-			return false, fmt.Errorf("unknown reply status error: %w", err)
-		}
-		if st.Code() == codes.FailedPrecondition {
-			// GKE cannot have two simultaneous nodepool operations running on it
-			// Waiting for any ongoing operation to complete before starting new one
-			log.Printf("Cluster in 'FailedPrecondition' state '%s'", err)
-
-			return false, nil
-		}
-		return false, err
-	}
-	log.Printf("cluster node pool status: `%v`", rep.Status)
-	return true, nil
-}
-
 // NodePoolDelete deletes a new k8s node-pool in an existing cluster.
-func (c *GKE) NodePoolDelete(*kingpin.ParseContext) error {
+func (c *GKE) NodePoolDelete(deployments []Resource) error {
 	// Use CreateNodePoolRequest struct to pass the UnmarshalStrict validation and
 	// than use the result to create the DeleteNodePoolRequest
 	reqC := &containerpb.CreateClusterRequest{}
-	for _, deployment := range c.gkeResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, reqC); err != nil {
-			log.Fatalf("Error parsing the cluster deployment file %s:%v", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		for _, node := range reqC.Cluster.NodePools {
@@ -404,43 +364,21 @@ func (c *GKE) NodePoolDelete(*kingpin.ParseContext) error {
 			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 			log.Printf("Removing cluster node pool: `%v`,  cluster '%v', project '%v', zone '%v'", reqD.NodePoolId, reqD.ClusterId, reqD.ProjectId, reqD.Zone)
 
-			err := provider.RetryUntilTrue(
+			op, err := c.startOperation("deleting nodepool "+reqD.NodePoolId, true, func() (*containerpb.Operation, error) {
+				return c.clientGKE.DeleteNodePool(c.ctx, reqD)
+			})
+			if err == nil {
 				//nolint:staticcheck // SA1019 - Ignore "Do not use.".
-				fmt.Sprintf("deleting nodepool:%v", reqD.NodePoolId),
-				provider.GlobalRetryCount,
-				func() (bool, error) { return c.nodePoolDeleted(reqD) })
+				err = c.waitOperation(reqD.ProjectId, reqD.Zone, op, "deleting nodepool "+reqD.NodePoolId)
+			}
 			if err != nil {
-				log.Fatalf("Couldn't delete cluster nodepool '%v', file:%v ,err: %v", node.Name, deployment.FileName, err)
+				return fmt.Errorf("deleting cluster nodepool %v, file: %v: %w", node.Name, deployment.FileName, err)
 			}
 		}
 	}
 	return nil
 }
 
-// nodePoolDeleted checks whether a nodepool has been deleted.
-func (c *GKE) nodePoolDeleted(req *containerpb.DeleteNodePoolRequest) (bool, error) {
-	rep, err := c.clientGKE.DeleteNodePool(c.ctx, req)
-	if err != nil {
-		st, ok := status.FromError(err)
-		if !ok {
-			return false, fmt.Errorf("unknown reply status error: %w", err)
-		}
-		if st.Code() == codes.NotFound {
-			return true, nil
-		}
-		if st.Code() == codes.FailedPrecondition {
-			// GKE cannot have two simultaneous nodepool operations running on it
-			// Waiting for any ongoing operation to complete before starting new one
-			log.Printf("Cluster in 'FailedPrecondition' state '%s'", err)
-
-			return false, nil
-		}
-		return false, err
-	}
-	log.Printf("cluster node pool status: `%v`", rep.Status)
-	return false, nil
-}
-
 // nodePoolRunning checks whether a nodepool has been created and is running.
 func (c *GKE) nodePoolRunning(zone, projectID, clusterID, poolName string) (bool, error) {
 	req := &containerpb.GetNodePoolRequest{
@@ -455,7 +393,7 @@ func (c *GKE) nodePoolRunning(zone, projectID, clusterID, poolName string) (bool
 		if st, ok := status.FromError(err); ok && st.Code() == codes.NotFound {
 			return false, nil
 		}
-		return false, fmt.Errorf("Couldn't get node pool status: %w", err)
+		return false, apiError("getting status of nodepool "+poolName, err)
 	}
 	if rep.Status == containerpb.NodePool_RUNNING {
 		return true, nil
@@ -466,7 +404,7 @@ func (c *GKE) nodePoolRunning(zone, projectID, clusterID, poolName string) (bool
 		rep.Status == containerpb.NodePool_STOPPING ||
 		rep.Status == containerpb.NodePool_STATUS_UNSPECIFIED {
 		//nolint:staticcheck // SA1019 - Ignore "Do not use.".
-		log.Fatalf("NodePool %s not in a status to become ready: %v", rep.Name, rep.StatusMessage)
+		return false, apiError("creating nodepool "+rep.Name, fmt.Errorf("nodepool not in a status to become ready: %v %v", rep.Status, rep.StatusMessage))
 	}
 
 	//nolint:staticcheck // SA1019 - Ignore "Do not use.".
@@ -474,23 +412,23 @@ func (c *GKE) nodePoolRunning(zone, projectID, clusterID, poolName string) (bool
 	return false, nil
 }
 
-// AllNodepoolsRunning returns an error if at least one node pool is not running.
-func (c *GKE) AllNodepoolsRunning(*kingpin.ParseContext) error {
+// NodePoolsRunning returns an error if at least one node pool is not running.
+func (c *GKE) NodePoolsRunning(deployments []Resource) error {
 	reqC := &containerpb.CreateClusterRequest{}
 
-	for _, deployment := range c.gkeResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, reqC); err != nil {
-			return fmt.Errorf("error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		for _, node := range reqC.Cluster.NodePools {
 			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 			isRunning, err := c.nodePoolRunning(reqC.Zone, reqC.ProjectId, reqC.Cluster.Name, node.Name)
 			if err != nil {
-				log.Fatalf("error fetching nodePool info")
+				return fmt.Errorf("error fetching nodePool info: %w", err)
 			}
 			if !isRunning {
-				log.Fatalf("nodepool not running name: %v", node.Name)
+				return fmt.Errorf("nodepool not running name: %v", node.Name)
 			}
 		}
 	}
@@ -498,23 +436,23 @@ func (c *GKE) AllNodepoolsRunning(*kingpin.ParseContext) error {
 	return nil
 }
 
-// AllNodepoolsDeleted returns an error if at least one nodepool is not deleted.
-func (c *GKE) AllNodepoolsDeleted(*kingpin.ParseContext) error {
+// NodePoolsDeleted returns an error if at least one nodepool is not deleted.
+func (c *GKE) NodePoolsDeleted(deployments []Resource) error {
 	reqC := &containerpb.CreateClusterRequest{}
 
-	for _, deployment := range c.gkeResources {
+	for _, deployment := range deployments {
 		if err := yamlGo.UnmarshalStrict(deployment.Content, reqC); err != nil {
-			return fmt.Errorf("error parsing the cluster deployment file %s: %w", deployment.FileName, err)
+			return &provider.DecodeError{File: deployment.FileName, Err: err}
 		}
 
 		for _, node := range reqC.Cluster.NodePools {
 			//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 			isRunning, err := c.nodePoolRunning(reqC.Zone, reqC.ProjectId, reqC.Cluster.Name, node.Name)
 			if err != nil {
-				log.Fatalf("error fetching nodePool info")
+				return fmt.Errorf("error fetching nodePool info: %w", err)
 			}
 			if isRunning {
-				log.Fatalf("nodepool running name: %v", node.Name)
+				return fmt.Errorf("nodepool running name: %v", node.Name)
 			}
 		}
 	}
@@ -522,8 +460,8 @@ func (c *GKE) AllNodepoolsDeleted(*kingpin.ParseContext) error {
 	return nil
 }
 
-// NewK8sProvider sets the k8s provider used for deploying k8s manifests.
-func (c *GKE) NewK8sProvider(*kingpin.ParseContext) error {
+// KubeConfig returns the config of the cluster with the master auth retrieved from GKE.
+func (c *GKE) KubeConfig() (*clientcmdapi.Config, error) {
 	// Get the authentication certificate for the cluster using the GKE client.
 	req := &containerpb.GetClusterRequest{
 		ProjectId: c.DeploymentVars["GKE_PROJECT_ID"],
@@ -532,13 +470,13 @@ func (c *GKE) NewK8sProvider(*kingpin.ParseContext) error {
 	}
 	rep, err := c.clientGKE.GetCluster(c.ctx, req)
 	if err != nil {
-		log.Fatalf("failed to get cluster details: %v", err)
+		return nil, apiError("getting cluster details of "+req.ClusterId, err)
 	}
 
 	// The master auth retrieved from GCP it is base64 encoded so it must be decoded first.
 	caCert, err := base64.StdEncoding.DecodeString(rep.MasterAuth.GetClusterCaCertificate())
 	if err != nil {
-		log.Fatalf("failed to decode certificate: %v", err.Error())
+		return nil, fmt.Errorf("failed to decode certificate: %w", err)
 	}
 
 	cluster := clientcmdapi.NewCluster()
@@ -569,35 +507,5 @@ func (c *GKE) NewK8sProvider(*kingpin.ParseContext) error {
 	//nolint:staticcheck // SA1019 - Ignore "Do not use.".
 	config.CurrentContext = rep.Zone
 
-	c.k8sProvider, err = k8sProvider.New(c.ctx, config)
-	if err != nil {
-		log.Fatal("k8s provider error", err)
-	}
-	return nil
-}
-
-// ResourceApply calls k8s.ResourceApply to apply the k8s objects in the manifest files.
-func (c *GKE) ResourceApply(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceApply(c.k8sResources, true); err != nil {
-		log.Fatal("error while applying a resource err:", err)
-	}
-	return nil
-}
-
-// ResourceDelete calls k8s.ResourceDelete to apply the k8s objects in the manifest files.
-func (c *GKE) ResourceDelete(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceDelete(c.k8sResources); err != nil {
-		log.Fatal("error while deleting objects from a manifest file err:", err)
-	}
-	return nil
-}
-
-// GetDeploymentVars shows deployment variables.
-func (c *GKE) GetDeploymentVars(_ *kingpin.ParseContext) error {
-	fmt.Print("-------------------\n   DeploymentVars   \n------------------- \n")
-	for key, value := range c.DeploymentVars {
-		fmt.Println(key, " : ", value)
-	}
-
-	return nil
+	return config, nil
 }
@@ -0,0 +1,480 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/container/apiv1/containerpb"
+	"google.golang.org/api/option"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+// fakeClusterManager is an in-process ClusterManager API serving the cluster, node pool and operation requests of the GKE provider.
+// Create and delete requests start an operation that is running until it is read once
+// and only then provisions or removes the resource. Delete requests for a resource with
+// a running operation are answered with FailedPrecondition, as GKE does.
+type fakeClusterManager struct {
+	containerpb.UnimplementedClusterManagerServer
+
+	mtx sync.Mutex
+	// clusters and nodePools map the resource names to their status.
+	clusters  map[string]containerpb.Cluster_Status
+	nodePools map[string]containerpb.NodePool_Status
+	// operations maps the operation names to the operations.
+	operations map[string]*fakeOperation
+	// failing resources end up in the ERROR status and fail their create operation.
+	failing map[string]bool
+	// preconditions is the number of create and delete requests answered with FailedPrecondition,
+	// as GKE does while another operation runs on the cluster.
+	preconditions int
+	// errCode, when set, fails all create and delete requests with this code.
+	errCode codes.Code
+}
+
+type fakeOperation struct {
+	op *containerpb.Operation
+	// done completes the operation and returns its error, if any.
+	done func() *spb.Status
+}
+
+func clusterName(projectID, zone, cluster string) string {
+	return "projects/" + projectID + "/locations/" + zone + "/clusters/" + cluster
+}
+
+func nodePoolName(projectID, zone, cluster, nodePool string) string {
+	return clusterName(projectID, zone, cluster) + "/nodePools/" + nodePool
+}
+
+// requestError returns the injected error of a create or delete request, if any.
+func (f *fakeClusterManager) requestError() error {
+	if f.errCode != codes.OK {
+		return status.Error(f.errCode, "injected error")
+	}
+	if f.preconditions > 0 {
+		f.preconditions--
+		return status.Error(codes.FailedPrecondition, "another operation is running on the cluster")
+	}
+	return nil
+}
+
+// operation starts a running operation on a resource.
+func (f *fakeClusterManager) operation(opType containerpb.Operation_Type, target string, done func() *spb.Status) *containerpb.Operation {
+	op := &containerpb.Operation{
+		Name:          fmt.Sprintf("operation-%d", len(f.operations)),
+		OperationType: opType,
+		Status:        containerpb.Operation_RUNNING,
+		TargetLink:    target,
+	}
+	f.operations[op.Name] = &fakeOperation{op: op, done: done}
+	return proto.Clone(op).(*containerpb.Operation)
+}
+
+// failed returns the error of the create operation of a resource, if it is failing.
+func (f *fakeClusterManager) failed(name string) *spb.Status {
+	if !f.failing[name] {
+		return nil
+	}
+	return status.New(codes.ResourceExhausted, "insufficient quota to create "+name).Proto()
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and operation fields.
+func (f *fakeClusterManager) GetOperation(_ context.Context, req *containerpb.GetOperationRequest) (*containerpb.Operation, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	o, ok := f.operations[req.OperationId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "operation "+req.OperationId+" not found")
+	}
+	rep := proto.Clone(o.op).(*containerpb.Operation)
+	if o.op.Status == containerpb.Operation_RUNNING {
+		rep.Progress = &containerpb.OperationProgress{
+			Stages: []*containerpb.OperationProgress{{Name: "provisioning", Status: containerpb.Operation_RUNNING}},
+		}
+		o.op.Status = containerpb.Operation_DONE
+		o.op.Error = o.done()
+	}
+	return rep, nil
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and cluster fields.
+func (f *fakeClusterManager) CreateCluster(_ context.Context, req *containerpb.CreateClusterRequest) (*containerpb.Operation, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if err := f.requestError(); err != nil {
+		return nil, err
+	}
+	name := clusterName(req.ProjectId, req.Zone, req.Cluster.Name)
+	if _, ok := f.clusters[name]; ok {
+		return nil, status.Error(codes.AlreadyExists, "cluster "+name+" already exists")
+	}
+	f.clusters[name] = containerpb.Cluster_PROVISIONING
+	return f.operation(containerpb.Operation_CREATE_CLUSTER, name, func() *spb.Status {
+		if err := f.failed(name); err != nil {
+			f.clusters[name] = containerpb.Cluster_ERROR
+			return err
+		}
+		f.clusters[name] = containerpb.Cluster_RUNNING
+		return nil
+	}), nil
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and cluster fields.
+func (f *fakeClusterManager) GetCluster(_ context.Context, req *containerpb.GetClusterRequest) (*containerpb.Cluster, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	name := clusterName(req.ProjectId, req.Zone, req.ClusterId)
+	st, ok := f.clusters[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "cluster "+name+" not found")
+	}
+	return &containerpb.Cluster{
+		Name:       req.ClusterId,
+		Zone:       req.Zone,
+		Status:     st,
+		Endpoint:   "10.0.0.1",
+		MasterAuth: &containerpb.MasterAuth{ClusterCaCertificate: "Y2VydA=="},
+	}, nil
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and cluster fields.
+func (f *fakeClusterManager) DeleteCluster(_ context.Context, req *containerpb.DeleteClusterRequest) (*containerpb.Operation, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	name := clusterName(req.ProjectId, req.Zone, req.ClusterId)
+	st, ok := f.clusters[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "cluster "+name+" not found")
+	}
+	if err := f.requestError(); err != nil {
+		return nil, err
+	}
+	if st == containerpb.Cluster_PROVISIONING || st == containerpb.Cluster_STOPPING {
+		return nil, status.Error(codes.FailedPrecondition, "cluster "+name+" has a running operation")
+	}
+	f.clusters[name] = containerpb.Cluster_STOPPING
+	return f.operation(containerpb.Operation_DELETE_CLUSTER, name, func() *spb.Status {
+		delete(f.clusters, name)
+		return nil
+	}), nil
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and cluster fields.
+func (f *fakeClusterManager) CreateNodePool(_ context.Context, req *containerpb.CreateNodePoolRequest) (*containerpb.Operation, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, ok := f.clusters[clusterName(req.ProjectId, req.Zone, req.ClusterId)]; !ok {
+		return nil, status.Error(codes.NotFound, "cluster "+req.ClusterId+" not found")
+	}
+	if err := f.requestError(); err != nil {
+		return nil, err
+	}
+	name := nodePoolName(req.ProjectId, req.Zone, req.ClusterId, req.NodePool.Name)
+	if _, ok := f.nodePools[name]; ok {
+		return nil, status.Error(codes.AlreadyExists, "node pool "+name+" already exists")
+	}
+	f.nodePools[name] = containerpb.NodePool_PROVISIONING
+	return f.operation(containerpb.Operation_CREATE_NODE_POOL, name, func() *spb.Status {
+		if err := f.failed(name); err != nil {
+			f.nodePools[name] = containerpb.NodePool_ERROR
+			return err
+		}
+		f.nodePools[name] = containerpb.NodePool_RUNNING
+		return nil
+	}), nil
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and cluster fields.
+func (f *fakeClusterManager) GetNodePool(_ context.Context, req *containerpb.GetNodePoolRequest) (*containerpb.NodePool, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	name := nodePoolName(req.ProjectId, req.Zone, req.ClusterId, req.NodePoolId)
+	st, ok := f.nodePools[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "node pool "+name+" not found")
+	}
+	return &containerpb.NodePool{Name: req.NodePoolId, Status: st}, nil
+}
+
+//nolint:staticcheck // SA1019 - The provider sets the deprecated project, zone and cluster fields.
+func (f *fakeClusterManager) DeleteNodePool(_ context.Context, req *containerpb.DeleteNodePoolRequest) (*containerpb.Operation, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	name := nodePoolName(req.ProjectId, req.Zone, req.ClusterId, req.NodePoolId)
+	st, ok := f.nodePools[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "node pool "+name+" not found")
+	}
+	if err := f.requestError(); err != nil {
+		return nil, err
+	}
+	if st == containerpb.NodePool_PROVISIONING || st == containerpb.NodePool_STOPPING {
+		return nil, status.Error(codes.FailedPrecondition, "node pool "+name+" has a running operation")
+	}
+	f.nodePools[name] = containerpb.NodePool_STOPPING
+	return f.operation(containerpb.Operation_DELETE_NODE_POOL, name, func() *spb.Status {
+		delete(f.nodePools, name)
+		return nil
+	}), nil
+}
+
+// newFakeGKE returns a GKE provider talking to a fake ClusterManager API.
+func newFakeGKE(t *testing.T) (*GKE, *fakeClusterManager) {
+	t.Helper()
+	t.Cleanup(provider.SetRetryBackoff(time.Millisecond, time.Millisecond))
+
+	f := &fakeClusterManager{
+		clusters:   map[string]containerpb.Cluster_Status{},
+		nodePools:  map[string]containerpb.NodePool_Status{},
+		operations: map[string]*fakeOperation{},
+		failing:    map[string]bool{},
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	containerpb.RegisterClusterManagerServer(srv, f)
+	go srv.Serve(lis) //nolint:errcheck // Serve returns when the server is stopped.
+	t.Cleanup(srv.Stop)
+
+	c := New(context.Background())
+	c.clientOptions = []option.ClientOption{
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+	if err := c.Init(map[string]string{"GKE_PROJECT_ID": "project", "ZONE": "europe-west3-a", "CLUSTER_NAME": "prombench"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.clientGKE.Close() })
+	return c, f
+}
+
+var (
+	testClusterName = clusterName("project", "europe-west3-a", "prombench")
+	clusterFile     = Resource{FileName: "cluster_gke.yaml", Content: []byte(`
+projectid: project
+zone: europe-west3-a
+cluster:
+  name: prombench
+  nodepools:
+  - name: main-node
+    initialnodecount: 1
+`)}
+	nodesFile = Resource{FileName: "nodes_gke.yaml", Content: []byte(`
+projectid: project
+zone: europe-west3-a
+cluster:
+  name: prombench
+  nodepools:
+  - name: prometheus-123
+    initialnodecount: 2
+  - name: nodes-123
+    initialnodecount: 1
+`)}
+)
+
+func TestCluster(t *testing.T) {
+	c, f := newFakeGKE(t)
+
+	// The create request is sent again while another operation runs.
+	f.preconditions = 2
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	if st := f.clusters[testClusterName]; st != containerpb.Cluster_RUNNING {
+		t.Fatalf("expected a running cluster, got status %v", st)
+	}
+
+	config, err := c.KubeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := config.Clusters["prombench"].Server; server != "https://10.0.0.1" {
+		t.Errorf("unexpected kubeconfig server %q", server)
+	}
+
+	f.preconditions = 2
+	if err := c.ClusterDelete([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.clusters[testClusterName]; ok {
+		t.Fatal("expected the cluster to be deleted")
+	}
+	// Deleting a cluster that doesn't exist succeeds.
+	if err := c.ClusterDelete([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterError(t *testing.T) {
+	c, f := newFakeGKE(t)
+	f.failing[testClusterName] = true
+
+	// The error of the failed create operation is returned.
+	err := c.ClusterCreate([]Resource{clusterFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || status.Code(cloudAPIErr.Err) != codes.ResourceExhausted || !strings.Contains(err.Error(), "insufficient quota") {
+		t.Fatalf("expected a CloudAPIError with the error of the create operation, got %v", err)
+	}
+}
+
+func TestClusterDeleteError(t *testing.T) {
+	c, f := newFakeGKE(t)
+	f.clusters[testClusterName] = containerpb.Cluster_RUNNING
+	f.errCode = codes.PermissionDenied
+
+	// Errors other than NotFound and FailedPrecondition stop the retries.
+	err := c.ClusterDelete([]Resource{clusterFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || status.Code(cloudAPIErr.Err) != codes.PermissionDenied {
+		t.Fatalf("expected a CloudAPIError with the PermissionDenied status, got %v", err)
+	}
+	if _, ok := f.clusters[testClusterName]; !ok {
+		t.Fatal("expected the cluster to be left")
+	}
+}
+
+func TestWaitOperation(t *testing.T) {
+	c, f := newFakeGKE(t)
+
+	// Operations that don't exist are errors.
+	err := c.waitOperation("project", "europe-west3-a", &containerpb.Operation{Name: "missing"}, "missing operation")
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || status.Code(cloudAPIErr.Err) != codes.NotFound {
+		t.Fatalf("expected a CloudAPIError with the NotFound status, got %v", err)
+	}
+
+	// Successful operations can have a status message.
+	f.operations["done"] = &fakeOperation{op: &containerpb.Operation{
+		Name:          "done",
+		Status:        containerpb.Operation_DONE,
+		StatusMessage: "node pool upgraded to the default version",
+	}}
+	if err := c.waitOperation("project", "europe-west3-a", &containerpb.Operation{Name: "done"}, "done operation"); err != nil {
+		t.Fatalf("expected a successful operation with a status message, got %v", err)
+	}
+}
+
+func TestNodePoolMissingCluster(t *testing.T) {
+	c, _ := newFakeGKE(t)
+
+	// Creating a node pool in a missing cluster fails right away.
+	err := c.NodePoolCreate([]Resource{nodesFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || status.Code(cloudAPIErr.Err) != codes.NotFound {
+		t.Fatalf("expected a CloudAPIError with the NotFound status, got %v", err)
+	}
+}
+
+func TestNodePools(t *testing.T) {
+	c, f := newFakeGKE(t)
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	f.preconditions = 1
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for running node pools")
+	}
+
+	f.preconditions = 1
+	if err := c.NodePoolDelete([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for deleted node pools")
+	}
+	if len(f.nodePools) != 0 {
+		t.Errorf("expected no node pools to be left, got %v", f.nodePools)
+	}
+}
+
+func TestNodePoolError(t *testing.T) {
+	c, f := newFakeGKE(t)
+	if err := c.ClusterCreate([]Resource{clusterFile}); err != nil {
+		t.Fatal(err)
+	}
+	name := nodePoolName("project", "europe-west3-a", "prombench", "nodes-123")
+	f.failing[name] = true
+
+	err := c.NodePoolCreate([]Resource{nodesFile})
+	var cloudAPIErr *provider.CloudAPIError
+	if !errors.As(err, &cloudAPIErr) || status.Code(cloudAPIErr.Err) != codes.ResourceExhausted {
+		t.Fatalf("expected a CloudAPIError with the error of the create operation, got %v", err)
+	}
+	if st := f.nodePools[name]; st != containerpb.NodePool_ERROR {
+		t.Fatalf("expected a node pool in ERROR status, got %v", st)
+	}
+
+	// The failed node pool can be deleted.
+	if err := c.NodePoolDelete([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.nodePools) != 0 {
+		t.Errorf("expected no node pools to be left, got %v", f.nodePools)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	c, _ := newFakeGKE(t)
+	bad := []Resource{{FileName: "nodes_gke.yaml", Content: []byte("cluster:\n  name: prombench\nunknown: 1\n")}}
+	for name, fn := range map[string]func([]Resource) error{
+		"ClusterCreate":    c.ClusterCreate,
+		"ClusterDelete":    c.ClusterDelete,
+		"NodePoolCreate":   c.NodePoolCreate,
+		"NodePoolDelete":   c.NodePoolDelete,
+		"NodePoolsRunning": c.NodePoolsRunning,
+		"NodePoolsDeleted": c.NodePoolsDeleted,
+	} {
+		var decodeErr *provider.DecodeError
+		if err := fn(bad); !errors.As(err, &decodeErr) || decodeErr.File != "nodes_gke.yaml" {
+			t.Errorf("%v: expected a DecodeError for nodes_gke.yaml, got %v", name, err)
+		}
+	}
+}
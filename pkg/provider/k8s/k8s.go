@@ -15,42 +15,45 @@ package k8s
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"gopkg.in/alecthomas/kingpin.v2"
-	admissionregistration "k8s.io/api/admissionregistration/v1"
 	appsV1 "k8s.io/api/apps/v1"
 	batchV1 "k8s.io/api/batch/v1"
 	apiCoreV1 "k8s.io/api/core/v1"
-	apiNetworkingV1 "k8s.io/api/networking/v1"
-	rbac "k8s.io/api/rbac/v1"
 	apiServerExtensionsV1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiServerExtensionsClient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
-	"k8s.io/client-go/util/retry"
 	_ "k8s.io/cloud-provider-gcp/pkg/clientauthplugin/gcp"
 
 	"github.com/prometheus/test-infra/pkg/provider"
 )
 
 func init() {
-	if err := apiServerExtensionsV1beta1.AddToScheme(scheme.Scheme); err != nil {
-		log.Fatal("apiServerExtensionsV1beta1.AddToScheme err:", err)
-	}
+	utilruntime.Must(apiServerExtensionsV1beta1.AddToScheme(scheme.Scheme))
 }
 
 // Resource holds the resource objects after parsing deployment files.
@@ -59,10 +62,30 @@ type Resource struct {
 	Objects  []runtime.Object
 }
 
+// ApplyOptions configures how ResourceApply manages the applied objects.
+type ApplyOptions struct {
+	// Inventory is set as the InventoryLabel value on every applied object.
+	// No label is set when it is empty.
+	Inventory string
+	// Prune deletes the objects labelled with the Inventory that are no longer in the applied manifests.
+	Prune bool
+	// PruneAllNamespaces prunes the namespaced objects in all namespaces
+	// instead of only in the namespaces of the applied manifests.
+	PruneAllNamespaces bool
+	// Workers is the number of files of a wave applied concurrently.
+	// The objects of a single file are always applied in order. Zero applies all files one by one.
+	Workers int
+}
+
 // K8s holds the fields used to generate API request from within a cluster.
 type K8s struct {
+	ApplyOptions
 	clt          *kubernetes.Clientset
 	ApiExtClient *apiServerExtensionsClient.Clientset
+	// dynClient is used to apply and delete objects of any kind, including custom resources.
+	dynClient dynamic.Interface
+	// mapper resolves the REST endpoint of an object from its kind.
+	mapper meta.ResettableRESTMapper
 	// DeploymentFiles files provided from the cli.
 	DeploymentFiles []string
 	// Variables to substitute in the DeploymentFiles.
@@ -70,6 +93,10 @@ type K8s struct {
 	DeploymentVars map[string]string
 	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
 	resources []Resource
+	// ReportWriter receives a JSON Report after every ResourceApply and ResourceDelete call when set.
+	ReportWriter io.Writer
+	// report records the outcome of the running operation.
+	report *Report
 
 	ctx context.Context
 }
@@ -97,10 +124,22 @@ func New(ctx context.Context, config *clientcmdapi.Config) (*K8s, error) {
 		return nil, fmt.Errorf("k8s api extensions client error: %w", err)
 	}
 
+	dynClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("k8s dynamic client error: %w", err)
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("k8s discovery client error: %w", err)
+	}
+
 	return &K8s{
 		ctx:            ctx,
 		clt:            clientset,
 		ApiExtClient:   apiExtClientset,
+		dynClient:      dynClient,
+		mapper:         restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
 		DeploymentVars: make(map[string]string),
 	}, nil
 }
@@ -115,1347 +154,436 @@ func (c *K8s) GetResources() []Resource {
 func (c *K8s) DeploymentsParse(*kingpin.ParseContext) error {
 	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
 	if err != nil {
-		log.Fatalf("Couldn't parse deployment files: %v", err)
-	}
-
-	for _, deployment := range deploymentResource {
-		decode := scheme.Codecs.UniversalDeserializer().Decode
-		k8sObjects := make([]runtime.Object, 0)
-
-		for _, text := range strings.Split(string(deployment.Content), provider.Separator) {
-			text = strings.TrimSpace(text)
-			if len(text) == 0 {
-				continue
-			}
-
-			resource, _, err := decode([]byte(text), nil, nil)
-			if err != nil {
-				return fmt.Errorf("decoding the resource file:%v, section:%v...: %w", deployment.FileName, text[:100], err)
-			}
-			if resource == nil {
-				continue
-			}
-			k8sObjects = append(k8sObjects, resource)
-		}
-		if len(k8sObjects) > 0 {
-			c.resources = append(c.resources, Resource{FileName: deployment.FileName, Objects: k8sObjects})
-		}
-	}
-	return nil
-}
-
-// ResourceApply applies k8s objects.
-// The input is a slice of structs containing the filename and the slice of k8s objects present in the file.
-// If wait is true, each wave blocks until all deployments and stateful sets in it are ready.
-func (c *K8s) ResourceApply(deployments []Resource, wait bool) error {
-	// Group deployments by wave number extracted from filename prefix.
-	waves := make(map[int][]Resource)
-	for _, deployment := range deployments {
-		wave := extractWave(deployment.FileName)
-		waves[wave] = append(waves[wave], deployment)
-	}
-
-	// Sort wave numbers.
-	waveNums := make([]int, 0, len(waves))
-	for w := range waves {
-		waveNums = append(waveNums, w)
-	}
-	sort.Ints(waveNums)
-
-	// Apply and optionally wait wave by wave.
-	for _, wave := range waveNums {
-		if err := c.applyWave(wave, waves[wave], wait); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// applyWave applies all resources in a wave and, if wait is true, waits for
-// any deployments or stateful sets in that wave to become ready.
-func (c *K8s) applyWave(wave int, deployments []Resource, wait bool) error {
-	type pendingResource struct {
-		fileName string
-		obj      runtime.Object
-	}
-	var pendingDeployments []pendingResource
-	var pendingStatefulSets []pendingResource
-
-	var err error
-	for _, deployment := range deployments {
-		for _, resource := range deployment.Objects {
-			switch kind := strings.ToLower(resource.GetObjectKind().GroupVersionKind().Kind); kind {
-			case "clusterrole":
-				err = c.clusterRoleApply(resource)
-			case "clusterrolebinding":
-				err = c.clusterRoleBindingApply(resource)
-			case "configmap":
-				err = c.configMapApply(resource)
-			case "daemonset":
-				err = c.daemonSetApply(resource)
-			case "deployment":
-				err = c.deploymentCreate(resource)
-				if err == nil {
-					pendingDeployments = append(pendingDeployments, pendingResource{deployment.FileName, resource})
-				}
-			case "ingress":
-				err = c.ingressApply(resource)
-			case "namespace":
-				err = c.nameSpaceApply(resource)
-			case "role":
-				err = c.roleApply(resource)
-			case "rolebinding":
-				err = c.roleBindingApply(resource)
-			case "service":
-				err = c.serviceApply(resource)
-			case "serviceaccount":
-				err = c.serviceAccountApply(resource)
-			case "secret":
-				err = c.secretApply(resource)
-			case "persistentvolumeclaim":
-				err = c.persistentVolumeClaimApply(resource)
-			case "customresourcedefinition":
-				err = c.customResourceApply(resource)
-			case "statefulset":
-				err = c.statefulSetCreate(resource)
-				if err == nil {
-					pendingStatefulSets = append(pendingStatefulSets, pendingResource{deployment.FileName, resource})
-				}
-			case "job":
-				err = c.jobApply(resource)
-			case "validatingwebhookconfiguration":
-				err = c.validatingWebhookConfigurationApply(resource)
-			case "ingressclass":
-				err = c.ingressClassApply(resource)
-			default:
-				err = fmt.Errorf("creating request for unimplimented resource type:%v", kind)
-			}
-			if err != nil {
-				return fmt.Errorf("error applying '%v' err: %w", deployment.FileName, err)
-			}
-		}
-	}
-
-	var checkers []provider.Checker
-	for _, p := range pendingDeployments {
-		p := p
-		req := p.obj.(*appsV1.Deployment)
-		checkers = append(checkers, provider.Checker{
-			Name:  req.Name,
-			Check: func() (bool, error) { return c.deploymentReady(p.obj) },
-		})
-	}
-	for _, p := range pendingStatefulSets {
-		p := p
-		req := p.obj.(*appsV1.StatefulSet)
-		checkers = append(checkers, provider.Checker{
-			Name:  req.Name,
-			Check: func() (bool, error) { return c.statefulSetReady(p.obj) },
-		})
-	}
-	if wait && len(checkers) > 0 {
-		log.Printf("Waiting for wave %d readiness (%d resources)...", wave, len(checkers))
-		if err := provider.RetryUntilAllTrue(provider.GlobalRetryCount, checkers); err != nil {
-			return fmt.Errorf("error waiting for wave %d resources: %w", wave, err)
-		}
-	}
-	return nil
-}
-
-// extractWave returns the wave number from a filename by splitting on "_"
-// and parsing the first part as an integer.
-// For example, "path/to/4_fake-webserver.yaml" returns 4.
-// If no valid integer prefix is found, it returns 0.
-func extractWave(fileName string) int {
-	base := filepath.Base(fileName)
-	parts := strings.SplitN(base, "_", 2)
-	if len(parts) < 2 {
-		return 0
-	}
-	n, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0
-	}
-	return n
-}
-
-// ResourceDelete deletes k8s objects.
-// The input is a slice of structs containing the filename and the slice of k8s objects present in the file.
-func (c *K8s) ResourceDelete(deployments []Resource) error {
-	var err error
-	for _, deployment := range deployments {
-		for _, resource := range deployment.Objects {
-			switch kind := strings.ToLower(resource.GetObjectKind().GroupVersionKind().Kind); kind {
-			case "clusterrole":
-				err = c.clusterRoleDelete(resource)
-			case "clusterrolebinding":
-				err = c.clusterRoleBindingDelete(resource)
-			case "configmap":
-				err = c.configMapDelete(resource)
-			case "daemonset":
-				err = c.daemonsetDelete(resource)
-			case "deployment":
-				err = c.deploymentDelete(resource)
-			case "ingress":
-				err = c.ingressDelete(resource)
-			case "namespace":
-				err = c.namespaceDelete(resource)
-			case "role":
-				err = c.roleDelete(resource)
-			case "rolebinding":
-				err = c.roleBindingDelete(resource)
-			case "service":
-				err = c.serviceDelete(resource)
-			case "serviceaccount":
-				err = c.serviceAccountDelete(resource)
-			case "secret":
-				err = c.secretDelete(resource)
-			case "persistentvolumeclaim":
-				err = c.persistentVolumeClaimDelete(resource)
-			case "customresourcedefinition":
-				err = c.customResourceDelete(resource)
-			case "statefulset":
-				err = c.statefulSetDelete(resource)
-			case "job":
-				err = c.jobDelete(resource)
-			case "validatingwebhookconfiguration":
-				err = c.validatingWebhookConfigurationDelete(resource)
-			case "ingressclass":
-				err = c.ingressClassDelete(resource)
-			default:
-				err = fmt.Errorf("deleting request for unimplimented resource type:%v", kind)
-			}
-			if err != nil {
-				return fmt.Errorf("error deleting '%v' err: %w", deployment.FileName, err)
-			}
-		}
-	}
-	return nil
-}
-
-// Functions to create different K8s objects.
-func (c *K8s) clusterRoleApply(resource runtime.Object) error {
-	req := resource.(*rbac.ClusterRole)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().ClusterRoles()
-
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("listing resource : %v: %w", kind, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-		return nil
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-}
-
-func (c *K8s) clusterRoleBindingApply(resource runtime.Object) error {
-	req := resource.(*rbac.ClusterRoleBinding)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().ClusterRoleBindings()
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) configMapApply(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.ConfigMap)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().ConfigMaps(req.Namespace)
-
-		// Set API version and kind for server-side apply.
-		req.APIVersion = "v1"
-		req.Kind = "ConfigMap"
-
-		// Marshal the ConfigMap to JSON for server-side apply.
-		data, err := json.Marshal(req)
-		if err != nil {
-			return fmt.Errorf("error marshaling ConfigMap: %w", err)
-		}
-
-		// Use server-side apply via Patch method.
-		// This avoids storing last-applied-configuration in annotations.
-		force := true
-		_, err = client.Patch(c.ctx, req.Name, types.ApplyPatchType, data, apiMetaV1.PatchOptions{
-			FieldManager: "test-infra",
-			Force:        &force,
-		})
-		if err != nil {
-			return fmt.Errorf("resource apply failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource applied - kind: %v, name: %v", kind, req.Name)
-		return nil
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-}
-
-func (c *K8s) daemonSetApply(resource runtime.Object) error {
-	req := resource.(*appsV1.DaemonSet)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AppsV1().DaemonSets(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return c.daemonsetReady(resource)
-}
-
-func (c *K8s) deploymentCreate(resource runtime.Object) error {
-	req := resource.(*appsV1.Deployment)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AppsV1().Deployments(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-		} else {
-			if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-				return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-		}
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) statefulSetCreate(resource runtime.Object) error {
-	req := resource.(*appsV1.StatefulSet)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AppsV1().StatefulSets(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-		} else {
-			if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-				return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-		}
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) jobApply(resource runtime.Object) error {
-	req := resource.(*batchV1.Job)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.BatchV1().Jobs(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	const Infinite int = 1<<31 - 1
-	return provider.RetryUntilTrue(
-		fmt.Sprintf("running job:%v", req.Name),
-		Infinite,
-		func() (bool, error) { return c.jobReady(resource) })
-}
-
-func (c *K8s) validatingWebhookConfigurationApply(resource runtime.Object) error {
-	req := resource.(*admissionregistration.ValidatingWebhookConfiguration)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AdmissionregistrationV1().ValidatingWebhookConfigurations()
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-		var exists bool
-		for _, l := range list.Items {
-			if req.ResourceVersion == "" {
-				req.ResourceVersion = l.ResourceVersion
-			}
-
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) customResourceApply(resource runtime.Object) error {
-	req := resource.(*apiServerExtensionsV1beta1.CustomResourceDefinition)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1beta1":
-		client := c.ApiExtClient.ApiextensionsV1beta1().CustomResourceDefinitions()
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-
-	return nil
-}
-
-func (c *K8s) ingressApply(resource runtime.Object) error {
-	req := resource.(*apiNetworkingV1.Ingress)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.NetworkingV1().Ingresses(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) ingressClassApply(resource runtime.Object) error {
-	req, ok := resource.(*apiNetworkingV1.IngressClass)
-	if !ok {
-		return fmt.Errorf("expected IngressClass, but got %T", resource)
-	}
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	if resource.GetObjectKind().GroupVersionKind().Version != "v1" {
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'",
-			resource.GetObjectKind().GroupVersionKind().Version, kind, req.Name)
-	}
-
-	client := c.clt.NetworkingV1().IngressClasses()
-	list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("error listing resource: %v, name: %v: %w", kind, req.Name, err)
-	}
-
-	for _, l := range list.Items {
-		if l.Name == req.Name {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		}
+		return fmt.Errorf("couldn't parse deployment files: %w", err)
 	}
-
-	if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-		return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-	}
-	log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	return nil
-}
-
-func (c *K8s) nameSpaceApply(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.Namespace)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Namespaces()
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) roleApply(resource runtime.Object) error {
-	req := resource.(*rbac.Role)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().Roles(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) roleBindingApply(resource runtime.Object) error {
-	req := resource.(*rbac.RoleBinding)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().RoleBindings(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) serviceAccountApply(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.ServiceAccount)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().ServiceAccounts(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) serviceApply(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.Service)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Services(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				// Immutable fields must be set when updating.
-				// See https://github.com/kubernetes/kubernetes/pull/66602
-				if req.ResourceVersion == "" {
-					req.ResourceVersion = l.ResourceVersion
-				}
-				if req.Spec.ClusterIP == "" {
-					req.Spec.ClusterIP = l.Spec.ClusterIP
-				}
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-
-	return provider.RetryUntilTrue(
-		fmt.Sprintf("applying service:%v", req.Name),
-		provider.GlobalRetryCount,
-		func() (bool, error) { return c.serviceExists(resource) })
-}
-
-func (c *K8s) secretApply(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.Secret)
-	kind := req.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Secrets(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
-
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
-			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
-			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+
+	resources, err := Decode(deploymentResource)
+	if err != nil {
+		return err
 	}
+	c.resources = append(c.resources, resources...)
 	return nil
 }
 
-func (c *K8s) persistentVolumeClaimApply(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.PersistentVolumeClaim)
-	kind := req.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().PersistentVolumeClaims(req.Namespace)
-		list, err := client.List(c.ctx, apiMetaV1.ListOptions{})
-		if err != nil {
-			return fmt.Errorf("error listing resource : %v, name: %v: %w", kind, req.Name, err)
-		}
+// Decode decodes the parsed deployment files and returns the k8s objects grouped by the filename.
+// Kinds that are not known to the client-go scheme, like custom resources, are decoded as unstructured objects.
+func Decode(deploymentResource []provider.Resource) ([]Resource, error) {
+	var resources []Resource
+	for _, deployment := range deploymentResource {
+		k8sObjects := make([]runtime.Object, 0)
 
-		var exists bool
-		for _, l := range list.Items {
-			if l.Name == req.Name {
-				exists = true
-				break
+		for i, text := range provider.SplitDocuments(deployment.Content) {
+			resource, err := decodeObject(text)
+			if err != nil {
+				return nil, &provider.DecodeError{File: deployment.FileName, Document: i + 1, Err: err}
 			}
-		}
-
-		if exists {
-			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				_, err := client.Update(c.ctx, req, apiMetaV1.UpdateOptions{})
-				return err
-			}); err != nil {
-				return fmt.Errorf("resource update failed - kind: %v, name: %v: %w", kind, req.Name, err)
+			if resource == nil {
+				continue
 			}
-			log.Printf("resource updated - kind: %v, name: %v", kind, req.Name)
-			return nil
-		} else if _, err := client.Create(c.ctx, req, apiMetaV1.CreateOptions{}); err != nil {
-			return fmt.Errorf("resource creation failed - kind: %v, name: %v: %w", kind, req.Name, err)
+			k8sObjects = append(k8sObjects, resource)
+		}
+		if len(k8sObjects) > 0 {
+			resources = append(resources, Resource{FileName: deployment.FileName, Objects: k8sObjects})
 		}
-		log.Printf("resource created - kind: %v, name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
 	}
-	return nil
+	return resources, nil
 }
 
-// Functions to delete different K8s objects.
-func (c *K8s) clusterRoleDelete(resource runtime.Object) error {
-	req := resource.(*rbac.ClusterRole)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
+// decodeObject decodes a single yaml document into a typed object
+// or into an unstructured object when its kind isn't registered in the scheme.
+func decodeObject(text []byte) (runtime.Object, error) {
+	resource, _, err := scheme.Codecs.UniversalDeserializer().Decode(text, nil, nil)
+	if err == nil {
+		return resource, nil
+	}
+	if !runtime.IsNotRegisteredError(err) {
+		return nil, err
+	}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().ClusterRoles()
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	data, err := yaml.ToJSON(text)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
-func (c *K8s) clusterRoleBindingDelete(resource runtime.Object) error {
-	req := resource.(*rbac.ClusterRoleBinding)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().ClusterRoleBindings()
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
+// ResourceApply applies k8s objects.
+// The input is a slice of structs containing the filename and the slice of k8s objects present in the file.
+// If wait is true, each wave blocks until all deployments, stateful sets, daemon sets, jobs
+// and LoadBalancer services in it are ready.
+// With Prune set, the orphaned objects of the inventory are deleted once all waves are applied.
+func (c *K8s) ResourceApply(deployments []Resource, wait bool) error {
+	c.startReport("apply")
+	return c.finishReport(c.resourceApply(deployments, wait))
 }
 
-func (c *K8s) configMapDelete(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.ConfigMap)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+func (c *K8s) resourceApply(deployments []Resource, wait bool) error {
+	if c.Prune && c.Inventory == "" {
+		return fmt.Errorf("pruning requires an inventory name")
 	}
+	waveNums, waves := groupByWave(deployments)
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().ConfigMaps(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
+	// Apply and optionally wait wave by wave.
+	for _, wave := range waveNums {
+		if err := c.applyWave(wave, waves[wave], wait); err != nil {
+			return err
 		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
 	}
-	return nil
-}
 
-func (c *K8s) daemonsetDelete(resource runtime.Object) error {
-	req := resource.(*appsV1.DaemonSet)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+	if !c.Prune {
+		return nil
 	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AppsV1().DaemonSets(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	orphans, err := c.orphans(deployments)
+	if err != nil {
+		return err
+	}
+	objects := make([]runtime.Object, 0, len(orphans))
+	for _, orphan := range orphans {
+		objects = append(objects, orphan)
+	}
+	if err := c.deleteAndWait(nil, []Resource{{Objects: objects}}, actionPruned); err != nil {
+		return fmt.Errorf("error pruning: %w", err)
 	}
 	return nil
 }
 
-func (c *K8s) deploymentDelete(resource runtime.Object) error {
-	req := resource.(*appsV1.Deployment)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+// groupByWave groups deployments by the wave number extracted from the filename prefix
+// and returns the sorted wave numbers together with the groups.
+func groupByWave(deployments []Resource) ([]int, map[int][]Resource) {
+	waves := make(map[int][]Resource)
+	for _, deployment := range deployments {
+		wave := provider.ExtractWave(deployment.FileName)
+		waves[wave] = append(waves[wave], deployment)
 	}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AppsV1().Deployments(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	waveNums := make([]int, 0, len(waves))
+	for w := range waves {
+		waveNums = append(waveNums, w)
 	}
-	return nil
+	sort.Ints(waveNums)
+	return waveNums, waves
 }
 
-func (c *K8s) statefulSetDelete(resource runtime.Object) error {
-	req := resource.(*appsV1.StatefulSet)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+// applyWave applies all resources in a wave and, if wait is true, waits for
+// the workloads and load balancers in that wave to become ready.
+// Up to Workers files are applied concurrently. A failing file doesn't stop the
+// other files of the wave, and all errors are returned together.
+func (c *K8s) applyWave(wave int, deployments []Resource, wait bool) error {
+	fileCheckers := make([][]provider.Checker, len(deployments))
+	errs := make([]error, len(deployments))
+	sem := make(chan struct{}, max(c.Workers, 1))
+	var wg sync.WaitGroup
+	for i, deployment := range deployments {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			fileCheckers[i], errs[i] = c.applyFile(wave, deployment)
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
 	}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AppsV1().StatefulSets(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
+	var checkers []provider.Checker
+	for _, fc := range fileCheckers {
+		checkers = append(checkers, fc...)
+	}
+	if wait && len(checkers) > 0 {
+		log.Printf("Waiting for wave %d readiness (%d resources)...", wave, len(checkers))
+		if err := provider.RetryUntilAllTrue(c.ctx, provider.GlobalRetryTimeout, checkers); err != nil {
+			return fmt.Errorf("error waiting for wave %d resources: %w", wave, err)
 		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
 	}
 	return nil
 }
 
-func (c *K8s) jobDelete(resource runtime.Object) error {
-	req := resource.(*batchV1.Job)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
+// applyFile applies the objects of a deployment file in order
+// and returns the readiness checkers of the objects that need waiting for.
+func (c *K8s) applyFile(wave int, deployment Resource) ([]provider.Checker, error) {
+	var checkers []provider.Checker
+	for _, resource := range deployment.Objects {
+		start := time.Now()
+		action, err := c.apply(resource)
+		entry := c.report.add(&wave, deployment.FileName, resource, action, err)
+		if err != nil {
+			return nil, fmt.Errorf("error applying '%v' err: %w", deployment.FileName, err)
+		}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.BatchV1().Jobs(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
+		if checker, ok := c.readinessChecker(resource); ok {
+			checker.Check = c.report.trackReady(entry, start, checker.Check)
+			checkers = append(checkers, checker)
 		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
 	}
-	return nil
+	return checkers, nil
 }
 
-func (c *K8s) customResourceDelete(resource runtime.Object) error {
-	req := resource.(*apiServerExtensionsV1beta1.CustomResourceDefinition)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1beta1":
-		client := c.ApiExtClient.ApiextensionsV1beta1().CustomResourceDefinitions()
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
+// readinessChecker returns the checker that reports when the object is ready
+// or false when the object kind is considered ready as soon as it is applied.
+func (c *K8s) readinessChecker(resource runtime.Object) (provider.Checker, bool) {
+	var check func() (bool, error)
+	switch kind := strings.ToLower(resource.GetObjectKind().GroupVersionKind().Kind); kind {
+	case "deployment":
+		check = func() (bool, error) { return c.deploymentReady(resource) }
+	case "statefulset":
+		check = func() (bool, error) { return c.statefulSetReady(resource) }
+	case "daemonset":
+		check = func() (bool, error) { return c.daemonsetReady(resource) }
+	case "job":
+		check = func() (bool, error) { return c.jobReady(resource) }
+	case "service":
+		// Only load balancers need to wait for an external address.
+		if svc, ok := resource.(*apiCoreV1.Service); !ok || svc.Spec.Type != apiCoreV1.ServiceTypeLoadBalancer {
+			return provider.Checker{}, false
 		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
+		check = func() (bool, error) { return c.serviceExists(resource) }
 	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+		return provider.Checker{}, false
 	}
-
-	return nil
+	return provider.Checker{
+		Name:  fmt.Sprintf("%v/%v", resource.GetObjectKind().GroupVersionKind().Kind, resource.(apiMetaV1.Object).GetName()),
+		Check: check,
+	}, true
 }
 
-func (c *K8s) ingressDelete(resource runtime.Object) error {
-	req := resource.(*apiNetworkingV1.Ingress)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
+// ResourceDelete deletes k8s objects.
+// The input is a slice of structs containing the filename and the slice of k8s objects present in the file.
+// Waves are deleted in the reverse order of ResourceApply and each wave blocks
+// until all its objects are gone, including the ones held by finalizers.
+func (c *K8s) ResourceDelete(deployments []Resource) error {
+	c.startReport("delete")
+	return c.finishReport(c.resourceDelete(deployments))
+}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.NetworkingV1().Ingresses(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
+func (c *K8s) resourceDelete(deployments []Resource) error {
+	waveNums, waves := groupByWave(deployments)
+	for i := len(waveNums) - 1; i >= 0; i-- {
+		wave := waveNums[i]
+		if err := c.deleteAndWait(&wave, waves[wave], actionDeleted); err != nil {
+			return fmt.Errorf("error deleting wave %d: %w", wave, err)
 		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
 	}
 	return nil
 }
 
-func (c *K8s) ingressClassDelete(resource runtime.Object) error {
-	req, ok := resource.(*apiNetworkingV1.IngressClass)
-	if !ok {
-		return fmt.Errorf("expected IngressClass, but got %T", resource)
+// deleteAndWait deletes the objects of the deployments in reverse order and waits until they are all gone.
+// The wave and action are only used for the report.
+func (c *K8s) deleteAndWait(wave *int, deployments []Resource, action string) error {
+	var checkers []provider.Checker
+	for i := len(deployments) - 1; i >= 0; i-- {
+		deployment := deployments[i]
+		for j := len(deployment.Objects) - 1; j >= 0; j-- {
+			resource := deployment.Objects[j]
+			start := time.Now()
+			checker, found, err := c.delete(resource)
+			if !found {
+				c.report.add(wave, deployment.FileName, resource, actionNotFound, err)
+			} else {
+				entry := c.report.add(wave, deployment.FileName, resource, action, err)
+				checker.Check = c.report.trackReady(entry, start, checker.Check)
+			}
+			if err != nil {
+				return err
+			}
+			checkers = append(checkers, checker)
+		}
 	}
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
+	if len(checkers) == 0 {
+		return nil
+	}
+	log.Printf("Waiting for deletion of %d resources...", len(checkers))
+	return provider.RetryUntilAllTrue(c.ctx, 2*provider.GlobalRetryTimeout, checkers)
+}
 
-	if resource.GetObjectKind().GroupVersionKind().Version != "v1" {
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'",
-			resource.GetObjectKind().GroupVersionKind().Version, kind, req.Name)
+// ResourceDiff prints what ResourceApply would do for every object without modifying the cluster.
+// Each object is applied with a server-side dry run and compared against its live state,
+// so the result includes defaulting and admission changes made by the api server.
+func (c *K8s) ResourceDiff(deployments []Resource) error {
+	if c.Prune && c.Inventory == "" {
+		return fmt.Errorf("pruning requires an inventory name")
+	}
+	waveNums, waves := groupByWave(deployments)
+	for _, wave := range waveNums {
+		for _, deployment := range waves[wave] {
+			for _, resource := range deployment.Objects {
+				action, diff, err := c.diff(resource)
+				if err != nil {
+					return fmt.Errorf("error diffing '%v' err: %w", deployment.FileName, err)
+				}
+				gvk := resource.GetObjectKind().GroupVersionKind()
+				name := resource.(apiMetaV1.Object).GetName()
+				fmt.Printf("wave %d: %v/%v %v (%v)\n", wave, gvk.Kind, name, action, deployment.FileName)
+				if diff != "" {
+					fmt.Println(diff)
+				}
+			}
+		}
 	}
 
-	client := c.clt.NetworkingV1().IngressClasses()
-	err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{})
+	if !c.Prune {
+		return nil
+	}
+	orphans, err := c.orphans(deployments)
 	if err != nil {
-		return fmt.Errorf("resource deletion failed - kind: %v, name: %v: %w", kind, req.Name, err)
+		return err
+	}
+	for _, orphan := range orphans {
+		fmt.Printf("%v/%v %v\n", orphan.GetKind(), orphan.GetName(), actionPruned)
 	}
-
-	log.Printf("resource deleted - kind: %v, name: %v", kind, req.Name)
 	return nil
 }
 
-func (c *K8s) namespaceDelete(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.Namespace)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
+// Actions reported for an object when diffing or applying it.
+const (
+	actionCreated   = "created"
+	actionUpdated   = "updated"
+	actionUnchanged = "unchanged"
+	actionPruned    = "pruned"
+	actionDeleted   = "deleted"
+	actionNotFound  = "not-found"
+	actionFailed    = "failed"
+)
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Namespaces()
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleting - kind: %v , name: %v", kind, req.Name)
-		return provider.RetryUntilTrue(
-			fmt.Sprintf("deleting namespace:%v", req.Name),
-			2*provider.GlobalRetryCount,
-			func() (bool, error) { return c.namespaceDeleted(resource) })
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+// diff returns the action applying the object would result in and,
+// for updates, a diff between the live and the would-be object.
+func (c *K8s) diff(resource runtime.Object) (string, string, error) {
+	live, applied, err := c.upsert(resource, true)
+	if meta.IsNoMatchError(err) {
+		// The kind will be registered by a CRD in the same manifests.
+		return actionCreated, "", nil
 	}
-}
-
-func (c *K8s) roleDelete(resource runtime.Object) error {
-	req := resource.(*rbac.Role)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+	if err != nil {
+		return "", "", err
 	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().Roles(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	if live == nil {
+		return actionCreated, "", nil
 	}
-	return nil
-}
 
-func (c *K8s) roleBindingDelete(resource runtime.Object) error {
-	req := resource.(*rbac.RoleBinding)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+	diff := cmp.Diff(comparableObject(live), comparableObject(applied))
+	if diff == "" {
+		return actionUnchanged, "", nil
 	}
+	return actionUpdated, diff, nil
+}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.RbacV1().RoleBindings(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+// comparableObject returns the object content without the fields
+// that the api server changes on every write.
+func comparableObject(u *unstructured.Unstructured) map[string]interface{} {
+	obj := u.DeepCopy().Object
+	for _, field := range [][]string{
+		{"metadata", "managedFields"},
+		{"metadata", "resourceVersion"},
+		{"metadata", "generation"},
+		{"metadata", "creationTimestamp"},
+		{"metadata", "uid"},
+		{"status"},
+	} {
+		unstructured.RemoveNestedField(obj, field...)
 	}
-	return nil
+	return obj
 }
 
-func (c *K8s) serviceDelete(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.Service)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+// apply creates or updates an object of any kind and returns whether it was created, updated or left unchanged.
+func (c *K8s) apply(resource runtime.Object) (string, error) {
+	live, applied, err := c.upsert(resource, false)
+	if err != nil {
+		return "", err
 	}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Services(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	action := actionUpdated
+	switch {
+	case live == nil:
+		action = actionCreated
+	case live.GetResourceVersion() == applied.GetResourceVersion():
+		action = actionUnchanged
 	}
-	return nil
+	log.Printf("resource %v - kind: %v, name: %v", action, applied.GetKind(), applied.GetName())
+	return action, nil
 }
 
-func (c *K8s) serviceAccountDelete(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.ServiceAccount)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+// delete deletes an object of any kind and returns a checker reporting when it is gone.
+// Objects that don't exist are skipped and reported as not found.
+func (c *K8s) delete(resource runtime.Object) (provider.Checker, bool, error) {
+	u, client, err := c.resourceClient(resource)
+	if meta.IsNoMatchError(err) {
+		// The CRD of the kind is already gone together with all its objects.
+		gvk := resource.GetObjectKind().GroupVersionKind()
+		log.Printf("resource already deleted - kind: %v , name: %v", gvk.Kind, resource.(apiMetaV1.Object).GetName())
+		return provider.Checker{
+			Name:  fmt.Sprintf("%v/%v", gvk.Kind, resource.(apiMetaV1.Object).GetName()),
+			Check: func() (bool, error) { return true, nil },
+		}, false, nil
 	}
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().ServiceAccounts(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	if err != nil {
+		return provider.Checker{}, true, err
+	}
+	checker := provider.Checker{
+		Name: fmt.Sprintf("%v/%v", u.GetKind(), u.GetName()),
+		Check: func() (bool, error) {
+			_, err := client.Get(c.ctx, u.GetName(), apiMetaV1.GetOptions{})
+			if apiErrors.IsNotFound(err) {
+				return true, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("checking deletion - kind: %v, name: %v: %w", u.GetKind(), u.GetName(), err)
+			}
+			return false, nil
+		},
 	}
-	return nil
-}
 
-func (c *K8s) secretDelete(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.Secret)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
+	delPolicy := apiMetaV1.DeletePropagationForeground
+	err = client.Delete(c.ctx, u.GetName(), apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy})
+	if apiErrors.IsNotFound(err) {
+		log.Printf("resource already deleted - kind: %v , name: %v", u.GetKind(), u.GetName())
+		return checker, false, nil
 	}
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Secrets(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+	if err != nil {
+		return provider.Checker{}, true, fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", u.GetKind(), u.GetName(), err)
 	}
-	return nil
+	log.Printf("resource deleting - kind: %v , name: %v", u.GetKind(), u.GetName())
+	return checker, true, nil
 }
 
-func (c *K8s) persistentVolumeClaimDelete(resource runtime.Object) error {
-	req := resource.(*apiCoreV1.PersistentVolumeClaim)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-	if len(req.Namespace) == 0 {
-		req.Namespace = "default"
-	}
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().PersistentVolumeClaims(req.Namespace)
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
-		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
+// resourceClient converts the object to its unstructured form and returns it together with
+// the dynamic client for its REST endpoint. Namespaced objects without a namespace are
+// placed in the "default" namespace.
+func (c *K8s) resourceClient(resource runtime.Object) (*unstructured.Unstructured, dynamic.ResourceInterface, error) {
+	gvk := resource.GetObjectKind().GroupVersionKind()
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	if err != nil {
+		return nil, nil, fmt.Errorf("converting kind:'%v' to unstructured: %w", gvk.Kind, err)
 	}
-	return nil
-}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	// Typed objects carry empty read-only fields which shouldn't be part of an apply request.
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
 
-func (c *K8s) validatingWebhookConfigurationDelete(resource runtime.Object) error {
-	req := resource.(*admissionregistration.ValidatingWebhookConfiguration)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind might have been registered by a CRD applied in an earlier wave.
+		c.mapper.Reset()
+		mapping, err = c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown object kind:'%v', version:'%v', name:'%v': %w", gvk.Kind, gvk.Version, u.GetName(), err)
+	}
 
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.AdmissionregistrationV1().ValidatingWebhookConfigurations()
-		delPolicy := apiMetaV1.DeletePropagationForeground
-		if err := client.Delete(c.ctx, req.Name, apiMetaV1.DeleteOptions{PropagationPolicy: &delPolicy}); err != nil {
-			return fmt.Errorf("resource delete failed - kind: %v, name: %v: %w", kind, req.Name, err)
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return u, c.dynClient.Resource(mapping.Resource), nil
+	}
+	if u.GetNamespace() == "" {
+		u.SetNamespace("default")
+		if obj, ok := resource.(apiMetaV1.Object); ok {
+			obj.SetNamespace("default")
 		}
-		log.Printf("resource deleted - kind: %v , name: %v", kind, req.Name)
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
 	}
-	return nil
+	return u, c.dynClient.Resource(mapping.Resource).Namespace(u.GetNamespace()), nil
 }
 
 func (c *K8s) serviceExists(resource runtime.Object) (bool, error) {
@@ -1586,7 +714,7 @@ func (c *K8s) jobReady(resource runtime.Object) (bool, error) {
 	}
 }
 
-func (c *K8s) daemonsetReady(resource runtime.Object) error {
+func (c *K8s) daemonsetReady(resource runtime.Object) (bool, error) {
 	req := resource.(*appsV1.DaemonSet)
 	kind := resource.GetObjectKind().GroupVersionKind().Kind
 	if len(req.Namespace) == 0 {
@@ -1599,30 +727,14 @@ func (c *K8s) daemonsetReady(resource runtime.Object) error {
 
 		res, err := client.Get(c.ctx, req.Name, apiMetaV1.GetOptions{})
 		if err != nil {
-			return fmt.Errorf("Checking DaemonSet resource:'%v' status failed err: %w", req.Name, err)
+			return false, fmt.Errorf("Checking DaemonSet resource:'%v' status failed err: %w", req.Name, err)
 		}
-		if res.Status.NumberUnavailable == 0 {
-			return nil
-		}
-	default:
-		return fmt.Errorf("unknown object version: %v kind:'%v', name:'%v'", v, kind, req.Name)
-	}
-	return nil
-}
-
-func (c *K8s) namespaceDeleted(resource runtime.Object) (bool, error) {
-	req := resource.(*apiCoreV1.Namespace)
-	kind := resource.GetObjectKind().GroupVersionKind().Kind
-
-	switch v := resource.GetObjectKind().GroupVersionKind().Version; v {
-	case "v1":
-		client := c.clt.CoreV1().Namespaces()
 
-		if _, err := client.Get(c.ctx, req.Name, apiMetaV1.GetOptions{}); err != nil {
-			if apiErrors.IsNotFound(err) {
-				return true, nil
-			}
-			return false, fmt.Errorf("Couldn't get namespace '%v' err: %w", req.Name, err)
+		// The pods need to be scheduled and ready on all eligible nodes with the latest spec.
+		if res.Status.ObservedGeneration >= res.Generation &&
+			res.Status.UpdatedNumberScheduled == res.Status.DesiredNumberScheduled &&
+			res.Status.NumberReady == res.Status.DesiredNumberScheduled {
+			return true, nil
 		}
 		return false, nil
 	default:
@@ -13,28 +13,578 @@
 
 package k8s
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
 
-func TestExtractWave(t *testing.T) {
+	"github.com/google/go-cmp/cmp"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	k8sTesting "k8s.io/client-go/testing"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+func TestDecodeObject(t *testing.T) {
 	tests := []struct {
-		fileName string
-		want     int
+		name         string
+		text         string
+		unstructured bool
 	}{
-		{"4_fake-webserver.yaml", 4},
-		{"path/to/5_prometheus-test-pr_deployment.yaml", 5},
-		{"10_loadgen.yaml", 10},
-		{"1_namespace.yaml", 1},
-		{"no_number.yaml", 0},
-		{"", 0},
-		{"nodash", 0},
-		{"/etc/scaler/webserver.yaml", 0},
+		{
+			name: "typed",
+			text: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: fake-webserver`,
+		},
+		{
+			name: "custom resource",
+			text: `apiVersion: monitoring.coreos.com/v1
+kind: PodMonitor
+metadata:
+  name: fake-webserver
+spec:
+  podMetricsEndpoints:
+  - port: metrics`,
+			unstructured: true,
+		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.fileName, func(t *testing.T) {
-			got := extractWave(tt.fileName)
-			if got != tt.want {
-				t.Errorf("extractWave(%q) = %d, want %d", tt.fileName, got, tt.want)
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := decodeObject([]byte(tt.text))
+			if err != nil {
+				t.Fatalf("decodeObject() err: %v", err)
+			}
+			if _, ok := obj.(*unstructured.Unstructured); ok != tt.unstructured {
+				t.Errorf("decodeObject() returned %T, want unstructured: %v", obj, tt.unstructured)
+			}
+			if got := obj.(apiMetaV1.Object).GetName(); got != "fake-webserver" {
+				t.Errorf("decodeObject() name = %q, want %q", got, "fake-webserver")
 			}
 		})
 	}
 }
+
+func TestDecodeError(t *testing.T) {
+	content := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: ok\n---\napiVersion: v1\nkind: ConfigMap\nmetadata: [\n"
+	_, err := Decode([]provider.Resource{{FileName: "1_configmaps.yaml", Content: []byte(content)}})
+	var decodeErr *provider.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Decode() err = %v, want a DecodeError", err)
+	}
+	if decodeErr.File != "1_configmaps.yaml" || decodeErr.Document != 2 {
+		t.Errorf("Decode() err for %v document %d, want 1_configmaps.yaml document 2", decodeErr.File, decodeErr.Document)
+	}
+}
+
+func TestComparableObject(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            "config",
+			"resourceVersion": "42",
+			"uid":             "1234",
+			"managedFields":   []interface{}{},
+		},
+		"data": map[string]interface{}{"key": "value"},
+	}}
+	want := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "config"},
+		"data":       map[string]interface{}{"key": "value"},
+	}
+	if diff := cmp.Diff(want, comparableObject(u)); diff != "" {
+		t.Errorf("comparableObject() mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := u.Object["metadata"].(map[string]interface{})["uid"]; !ok {
+		t.Errorf("comparableObject() modified the original object")
+	}
+}
+
+func TestReadinessChecker(t *testing.T) {
+	tests := []struct {
+		text string
+		name string
+		ok   bool
+	}{
+		{
+			text: `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: node-exporter`,
+			name: "DaemonSet/node-exporter",
+			ok:   true,
+		},
+		{
+			text: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate`,
+			name: "Job/migrate",
+			ok:   true,
+		},
+		{
+			text: `apiVersion: v1
+kind: Service
+metadata:
+  name: ingress-nginx
+spec:
+  type: LoadBalancer`,
+			name: "Service/ingress-nginx",
+			ok:   true,
+		},
+		{
+			text: `apiVersion: v1
+kind: Service
+metadata:
+  name: prometheus`,
+		},
+		{
+			text: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config`,
+		},
+	}
+
+	c := &K8s{}
+	for _, tc := range tests {
+		obj, err := decodeObject([]byte(tc.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checker, ok := c.readinessChecker(obj)
+		if ok != tc.ok {
+			t.Fatalf("%v: expected ok %v, got %v", tc.name, tc.ok, ok)
+		}
+		if checker.Name != tc.name {
+			t.Errorf("expected checker name %q, got %q", tc.name, checker.Name)
+		}
+	}
+}
+
+// resettableMapper adds a no-op Reset to a static mapper.
+type resettableMapper struct{ meta.RESTMapper }
+
+func (resettableMapper) Reset() {}
+
+func newFakeK8s(objects ...runtime.Object) *K8s {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, gvk := range pruneKinds {
+		scope := meta.RESTScopeNamespace
+		if gvk.Kind == "Namespace" || gvk.Kind == "ClusterRole" || gvk.Kind == "ClusterRoleBinding" {
+			scope = meta.RESTScopeRoot
+		}
+		mapper.Add(gvk, scope)
+		mapping, _ := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		listKinds[mapping.Resource] = gvk.Kind + "List"
+	}
+	return &K8s{
+		ctx:       context.Background(),
+		dynClient: dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...),
+		mapper:    resettableMapper{mapper},
+	}
+}
+
+func newUnstructured(apiVersion, kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	u.SetLabels(labels)
+	return u
+}
+
+func TestOrphans(t *testing.T) {
+	inventory := map[string]string{InventoryLabel: "prombench-1"}
+	objects := []runtime.Object{
+		newUnstructured("v1", "Namespace", "", "prombench-1", inventory),
+		// The labelled namespace was dropped from the manifests.
+		newUnstructured("v1", "Namespace", "", "prombench-old", inventory),
+		newUnstructured("v1", "ConfigMap", "prombench-1", "kept", inventory),
+		newUnstructured("v1", "ConfigMap", "prombench-1", "removed", inventory),
+		newUnstructured("v1", "ConfigMap", "prombench-1", "unlabelled", nil),
+		// A labelled object in a namespace that isn't in the manifests.
+		newUnstructured("v1", "ConfigMap", "monitoring", "other-namespace", inventory),
+		newUnstructured("v1", "Secret", "prombench-1", "removed-kind", inventory),
+		newUnstructured("rbac.authorization.k8s.io/v1", "ClusterRole", "", "removed-cluster-role", inventory),
+		newUnstructured("rbac.authorization.k8s.io/v1", "ClusterRole", "", "other-inventory", map[string]string{InventoryLabel: "prombench-2"}),
+	}
+	deployments := []Resource{{
+		FileName: "1_configmap.yaml",
+		Objects: []runtime.Object{
+			newUnstructured("v1", "Namespace", "", "prombench-1", nil),
+			newUnstructured("v1", "ConfigMap", "prombench-1", "kept", nil),
+		},
+	}}
+	orphans := func(c *K8s) []string {
+		t.Helper()
+		c.Inventory = "prombench-1"
+		orphans, err := c.orphans(deployments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range orphans {
+			got = append(got, o.GetKind()+"/"+o.GetName())
+		}
+		return got
+	}
+
+	// Objects in other namespaces survive.
+	expected := []string{"ClusterRole/removed-cluster-role", "ConfigMap/removed", "Namespace/prombench-old", "Secret/removed-kind"}
+	if diff := cmp.Diff(expected, orphans(newFakeK8s(objects...))); diff != "" {
+		t.Errorf("unexpected orphans (-want +got):\n%s", diff)
+	}
+
+	c := newFakeK8s(objects...)
+	c.PruneAllNamespaces = true
+	expected = []string{"ClusterRole/removed-cluster-role", "ConfigMap/other-namespace", "ConfigMap/removed", "Namespace/prombench-old", "Secret/removed-kind"}
+	if diff := cmp.Diff(expected, orphans(c)); diff != "" {
+		t.Errorf("unexpected orphans in all namespaces (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetInventoryLabel(t *testing.T) {
+	u := newUnstructured("v1", "ConfigMap", "default", "config", map[string]string{"app": "test"})
+	(&K8s{}).setInventoryLabel(u)
+	if diff := cmp.Diff(map[string]string{"app": "test"}, u.GetLabels()); diff != "" {
+		t.Errorf("expected no inventory label without an inventory (-want +got):\n%s", diff)
+	}
+
+	(&K8s{ApplyOptions: ApplyOptions{Inventory: "cluster-infra"}}).setInventoryLabel(u)
+	if diff := cmp.Diff(map[string]string{"app": "test", InventoryLabel: "cluster-infra"}, u.GetLabels()); diff != "" {
+		t.Errorf("unexpected labels (-want +got):\n%s", diff)
+	}
+}
+
+func TestResourceDelete(t *testing.T) {
+	c := newFakeK8s(
+		newUnstructured("v1", "ConfigMap", "default", "config", nil),
+		newUnstructured("apps/v1", "Deployment", "default", "prometheus", nil),
+		newUnstructured("rbac.authorization.k8s.io/v1", "ClusterRole", "", "prometheus", nil),
+	)
+	deployments := []Resource{
+		{
+			FileName: "1_rbac.yaml",
+			Objects:  []runtime.Object{newUnstructured("rbac.authorization.k8s.io/v1", "ClusterRole", "", "prometheus", nil)},
+		},
+		{
+			FileName: "2_prometheus.yaml",
+			Objects: []runtime.Object{
+				newUnstructured("v1", "ConfigMap", "default", "config", nil),
+				newUnstructured("apps/v1", "Deployment", "default", "prometheus", nil),
+			},
+		},
+		{
+			FileName: "3_missing.yaml",
+			Objects:  []runtime.Object{newUnstructured("v1", "Secret", "default", "missing", nil)},
+		},
+	}
+	var out bytes.Buffer
+	c.ReportWriter = &out
+	if err := c.ResourceDelete(deployments); err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	for _, action := range c.dynClient.(*dynamicFake.FakeDynamicClient).Actions() {
+		if action, ok := action.(k8sTesting.DeleteAction); ok {
+			deleted = append(deleted, action.GetResource().Resource+"/"+action.GetName())
+		}
+	}
+	expected := []string{"secrets/missing", "deployments/prometheus", "configmaps/config", "clusterroles/prometheus"}
+	if diff := cmp.Diff(expected, deleted); diff != "" {
+		t.Errorf("unexpected deletion order (-want +got):\n%s", diff)
+	}
+
+	report := Report{}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, o := range report.Objects {
+		actions = append(actions, fmt.Sprintf("%d %v/%v %v", *o.Wave, o.Kind, o.Name, o.Action))
+	}
+	expected = []string{"3 Secret/missing not-found", "2 Deployment/prometheus deleted", "2 ConfigMap/config deleted", "1 ClusterRole/prometheus deleted"}
+	if diff := cmp.Diff(expected, actions); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+	if report.Operation != "delete" || report.Error != "" {
+		t.Errorf("unexpected report operation %q or error %q", report.Operation, report.Error)
+	}
+}
+
+func TestResourceApplyWorkers(t *testing.T) {
+	c := newFakeK8s()
+	c.Workers = 2
+	fakeClient := c.dynClient.(*dynamicFake.FakeDynamicClient)
+	// The fake object tracker doesn't support server-side apply, so return the applied object as is.
+	fakeClient.PrependReactor("patch", "*", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(action.(k8sTesting.PatchAction).GetPatch()); err != nil {
+			return true, nil, err
+		}
+		u.SetResourceVersion("1")
+		return true, u, nil
+	})
+
+	var deployments []Resource
+	for _, name := range []string{"a", "b", "c"} {
+		deployments = append(deployments, Resource{
+			FileName: "1_" + name + ".yaml",
+			Objects:  []runtime.Object{newUnstructured("v1", "ConfigMap", "default", name, nil)},
+		})
+	}
+	for _, name := range []string{"unknown-1", "unknown-2"} {
+		deployments = append(deployments, Resource{
+			FileName: "1_" + name + ".yaml",
+			Objects:  []runtime.Object{newUnstructured("example.com/v1", "Unknown", "default", name, nil)},
+		})
+	}
+	deployments = append(deployments, Resource{
+		FileName: "2_next-wave.yaml",
+		Objects:  []runtime.Object{newUnstructured("v1", "ConfigMap", "default", "next-wave", nil)},
+	})
+
+	err := c.ResourceApply(deployments, false)
+	for _, file := range []string{"1_unknown-1.yaml", "1_unknown-2.yaml"} {
+		if err == nil || !strings.Contains(err.Error(), file) {
+			t.Errorf("expected the error to contain %v, got %v", file, err)
+		}
+	}
+
+	var applied []string
+	for _, action := range fakeClient.Actions() {
+		if action, ok := action.(k8sTesting.PatchAction); ok {
+			applied = append(applied, action.GetName())
+		}
+	}
+	sort.Strings(applied)
+	if diff := cmp.Diff([]string{"a", "b", "c"}, applied); diff != "" {
+		t.Errorf("expected all valid objects of the failed wave and none of the next wave to be applied (-want +got):\n%s", diff)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	live := newUnstructured("v1", "ConfigMap", "default", "config", nil)
+	live.SetResourceVersion("1")
+	c := newFakeK8s(live)
+	fakeClient := c.dynClient.(*dynamicFake.FakeDynamicClient)
+	attempts := 0
+	fakeClient.PrependReactor("patch", "configmaps", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if opts := action.(k8sTesting.PatchActionImpl).GetPatchOptions(); opts.FieldManager != fieldManager || opts.Force == nil || !*opts.Force {
+			t.Errorf("expected a forced apply by %v, got %+v", fieldManager, opts)
+		}
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(action.(k8sTesting.PatchAction).GetPatch()); err != nil {
+			return true, nil, err
+		}
+		// The api server merges the applied fields with the live object, without optimistic locking.
+		if u.GetResourceVersion() != "" {
+			t.Errorf("expected no resource version, got %q", u.GetResourceVersion())
+		}
+		u.SetResourceVersion("2")
+		return true, u, nil
+	})
+
+	live, applied, err := c.upsert(newUnstructured("v1", "ConfigMap", "default", "config", nil), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single apply request, got %d", attempts)
+	}
+	if live.GetResourceVersion() != "1" || applied.GetResourceVersion() != "2" {
+		t.Errorf("expected the live and the applied object, got %v and %v", live, applied)
+	}
+}
+
+func TestUpsertImmutableFieldChanged(t *testing.T) {
+	c := newFakeK8s(
+		newUnstructured("batch/v1", "Job", "default", "loadgen", nil),
+		newUnstructured("apps/v1", "Deployment", "default", "prometheus", nil),
+	)
+	fakeClient := c.dynClient.(*dynamicFake.FakeDynamicClient)
+	fakeClient.PrependReactor("patch", "jobs", func(k8sTesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apiErrors.NewInvalid(schema.GroupKind{Group: "batch", Kind: "Job"}, "loadgen", field.ErrorList{
+			field.Invalid(field.NewPath("spec", "template"), nil, "field is immutable"),
+		})
+	})
+	fakeClient.PrependReactor("patch", "deployments", func(k8sTesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apiErrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "prometheus", field.ErrorList{
+			field.Invalid(field.NewPath("spec", "selector"), nil, "field is immutable"),
+		})
+	})
+
+	// The dry run fails the same way, instead of reporting the object as unchanged.
+	for _, dryRun := range []bool{false, true} {
+		for _, obj := range []*unstructured.Unstructured{
+			newUnstructured("batch/v1", "Job", "default", "loadgen", nil),
+			newUnstructured("apps/v1", "Deployment", "default", "prometheus", nil),
+		} {
+			_, _, err := c.upsert(obj, dryRun)
+			if err == nil || !strings.Contains(err.Error(), "recreate") || !apiErrors.IsInvalid(err) {
+				t.Errorf("%v, dry run %v: expected an immutable field error asking to recreate the object, got %v", obj.GetKind(), dryRun, err)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	resources := []provider.Resource{
+		{
+			FileName: "1_namespace.yaml",
+			Content: []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: prombench-1
+  namespace: default
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: prometheus`),
+		},
+		{
+			FileName: "2_prometheus.yaml",
+			Content: []byte(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: prombench-1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prometheus
+  namespace: prombench-1
+spec:
+  replica: 2
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: prometheus
+---
+apiVersion: monitoring.coreos.com/v1
+kind: PodMonitor
+metadata:
+  name: prometheus
+  namespace: prombench-1
+spec:
+  anyField: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: prombench-1
+---
+kind: Secret
+metadata:
+  name: no-version`),
+		},
+		{
+			// The scope of built-in kinds comes from their clients and unknown custom kinds aren't checked.
+			FileName: "3_cluster.yaml",
+			Content: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: runtime
+  namespace: prombench-1
+---
+apiVersion: node.k8s.io/v1
+kind: RuntimeClass
+metadata:
+  name: gvisor
+handler: runsc
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: letsencrypt
+---
+apiVersion: monitoring.coreos.com/v1
+kind: PodMonitor
+metadata:
+  name: node-exporter
+  namespace: monitoring`),
+		},
+	}
+
+	resources = append(resources, provider.Resource{
+		// The scope of custom kinds comes from their definition in the files.
+		FileName: "4_crd.yaml",
+		Content: []byte(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: clusterwidgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: ClusterWidget
+    plural: clusterwidgets
+  scope: Cluster
+  versions:
+  - name: v1
+    served: true
+    storage: true
+---
+apiVersion: example.com/v1
+kind: ClusterWidget
+metadata:
+  name: widget
+  namespace: default`),
+	})
+
+	validate := func(mapper meta.RESTMapper) []string {
+		var got []string
+		for _, problem := range Validate(resources, mapper) {
+			entry := fmt.Sprintf("%v#%d", problem.File, problem.Document)
+			if problem.Warning {
+				entry += " warning"
+			}
+			got = append(got, entry)
+		}
+		return got
+	}
+	expected := []string{
+		"1_namespace.yaml#1",          // Cluster-scoped with a namespace.
+		"2_prometheus.yaml#2",         // Unknown field.
+		"2_prometheus.yaml#3 warning", // Different namespace.
+		"2_prometheus.yaml#5",         // Duplicate.
+		"2_prometheus.yaml#6",         // Decoding error.
+		"3_cluster.yaml#4 warning",    // Different namespace, PodMonitor is namespaced in the cluster.
+		"4_crd.yaml#2",                // Cluster-scoped custom kind with a namespace.
+	}
+	if diff := cmp.Diff(append(expected[:5:5], expected[6]), validate(nil)); diff != "" {
+		t.Errorf("unexpected problems without a cluster (-want +got):\n%s", diff)
+	}
+
+	// The cluster knows the scope of the custom kinds it serves.
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"}, meta.RESTScopeNamespace)
+	if diff := cmp.Diff(expected, validate(mapper)); diff != "" {
+		t.Errorf("unexpected problems with a cluster (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// InventoryLabel is the label identifying the objects applied from the same set of manifests.
+const InventoryLabel = "infra.prometheus.io/inventory"
+
+// pruneKinds are the kinds checked for orphaned objects in addition to the kinds in the applied manifests,
+// so that an object is still pruned after the last object of its kind is removed from the manifests.
+var pruneKinds = []schema.GroupVersionKind{
+	{Version: "v1", Kind: "Namespace"},
+	{Version: "v1", Kind: "ConfigMap"},
+	{Version: "v1", Kind: "Secret"},
+	{Version: "v1", Kind: "Service"},
+	{Version: "v1", Kind: "ServiceAccount"},
+	{Version: "v1", Kind: "PersistentVolumeClaim"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Group: "batch", Version: "v1", Kind: "CronJob"},
+	{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"},
+}
+
+// objectKey identifies an object independently of its api version.
+type objectKey struct {
+	groupKind schema.GroupKind
+	namespace string
+	name      string
+}
+
+// setInventoryLabel adds the inventory label to the object when an inventory is set.
+func (c *K8s) setInventoryLabel(u *unstructured.Unstructured) {
+	if c.Inventory == "" {
+		return
+	}
+	l := u.GetLabels()
+	if l == nil {
+		l = map[string]string{}
+	}
+	l[InventoryLabel] = c.Inventory
+	u.SetLabels(l)
+}
+
+// orphans returns the objects labelled with the inventory that are not in the deployments.
+// Namespaced objects are only looked up in the namespaces of the deployments, unless PruneAllNamespaces is set,
+// so objects of the same inventory in other namespaces are never touched. A labelled namespace that was
+// removed from the deployments is an orphan itself, which deletes all objects in it.
+func (c *K8s) orphans(deployments []Resource) ([]*unstructured.Unstructured, error) {
+	applied := map[objectKey]bool{}
+	namespaces := map[string]bool{}
+	kinds := map[schema.GroupKind]schema.GroupVersionKind{}
+	for _, gvk := range pruneKinds {
+		kinds[gvk.GroupKind()] = gvk
+	}
+	for _, deployment := range deployments {
+		for _, resource := range deployment.Objects {
+			gvk := resource.GetObjectKind().GroupVersionKind()
+			obj := resource.(apiMetaV1.Object)
+			applied[objectKey{gvk.GroupKind(), obj.GetNamespace(), obj.GetName()}] = true
+			if obj.GetNamespace() != "" {
+				namespaces[obj.GetNamespace()] = true
+			}
+			kinds[gvk.GroupKind()] = gvk
+		}
+	}
+
+	selector := labels.Set{InventoryLabel: c.Inventory}.AsSelector().String()
+	var orphans []*unstructured.Unstructured
+	for _, gvk := range kinds {
+		mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			// Nothing to prune when the kind isn't served by the cluster.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lookupNamespaces := []string{""}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace && !c.PruneAllNamespaces {
+			lookupNamespaces = sortedKeys(namespaces)
+		}
+		for _, ns := range lookupNamespaces {
+			// An empty namespace lists the objects of namespaced kinds in all namespaces.
+			list, err := c.dynClient.Resource(mapping.Resource).Namespace(ns).List(c.ctx, apiMetaV1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return nil, fmt.Errorf("listing %v objects of inventory %v: %w", gvk.Kind, c.Inventory, err)
+			}
+			for i := range list.Items {
+				item := &list.Items[i]
+				if !applied[objectKey{gvk.GroupKind(), item.GetNamespace(), item.GetName()}] {
+					item.SetGroupVersionKind(gvk)
+					orphans = append(orphans, item)
+				}
+			}
+		}
+	}
+
+	// Delete in a stable order so that the output is the same for every run.
+	sort.Slice(orphans, func(i, j int) bool {
+		a, b := orphans[i], orphans[j]
+		if a.GetKind() != b.GetKind() {
+			return a.GetKind() < b.GetKind()
+		}
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return orphans, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
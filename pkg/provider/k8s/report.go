@@ -0,0 +1,133 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Report is the machine-readable outcome of a ResourceApply or ResourceDelete call.
+type Report struct {
+	// Operation is either "apply" or "delete".
+	Operation       string          `json:"operation"`
+	StartTime       time.Time       `json:"startTime"`
+	DurationSeconds float64         `json:"durationSeconds"`
+	Objects         []*ReportObject `json:"objects"`
+	// Error is the error the operation failed with, if any.
+	Error string `json:"error,omitempty"`
+
+	mtx sync.Mutex
+}
+
+// ReportObject is the outcome for a single object.
+type ReportObject struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// File and Wave are not set for pruned objects as they are no longer in the manifests.
+	File string `json:"file,omitempty"`
+	Wave *int   `json:"wave,omitempty"`
+	// Action is one of created, updated, unchanged, deleted, not-found, pruned or failed.
+	Action string `json:"action"`
+	// ReadySeconds is the time from applying the object until it was ready,
+	// or from deleting it until it was gone.
+	// It is only set for the objects that were waited for.
+	ReadySeconds float64 `json:"readySeconds,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// startReport starts recording a report for the operation when a report writer is set.
+func (c *K8s) startReport(operation string) {
+	if c.ReportWriter == nil {
+		return
+	}
+	c.report = &Report{
+		Operation: operation,
+		StartTime: time.Now(),
+		Objects:   []*ReportObject{},
+	}
+}
+
+// finishReport writes the recorded report, including the error the operation failed with,
+// and returns that error.
+func (c *K8s) finishReport(err error) error {
+	r := c.report
+	if r == nil {
+		return err
+	}
+	c.report = nil
+
+	r.DurationSeconds = time.Since(r.StartTime).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	enc := json.NewEncoder(c.ReportWriter)
+	enc.SetIndent("", "  ")
+	if werr := enc.Encode(r); werr != nil {
+		return errors.Join(err, fmt.Errorf("writing report: %w", werr))
+	}
+	return err
+}
+
+// add records the outcome for an object. It returns nil when no report is recorded.
+func (r *Report) add(wave *int, file string, resource runtime.Object, action string, err error) *ReportObject {
+	if r == nil {
+		return nil
+	}
+	o := &ReportObject{
+		Kind:   resource.GetObjectKind().GroupVersionKind().Kind,
+		File:   file,
+		Wave:   wave,
+		Action: action,
+	}
+	if obj, ok := resource.(apiMetaV1.Object); ok {
+		o.Namespace = obj.GetNamespace()
+		o.Name = obj.GetName()
+	}
+	if err != nil {
+		o.Action = actionFailed
+		o.Error = err.Error()
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.Objects = append(r.Objects, o)
+	return o
+}
+
+// trackReady wraps the check so that the time until it first succeeds is recorded for the object.
+func (r *Report) trackReady(o *ReportObject, start time.Time, check func() (bool, error)) func() (bool, error) {
+	if o == nil {
+		return check
+	}
+	return func() (bool, error) {
+		ok, err := check()
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		switch {
+		case err != nil:
+			o.Error = err.Error()
+		case ok && o.ReadySeconds == 0:
+			o.ReadySeconds = time.Since(start).Seconds()
+		}
+		return ok, err
+	}
+}
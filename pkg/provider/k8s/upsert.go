@@ -0,0 +1,84 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fieldManager is the field manager name used for server-side apply requests.
+const fieldManager = "prombench-infra"
+
+// upsert creates or updates an object of any kind with a forced server-side apply owned by fieldManager.
+// The live object is fetched by name first for the diffs and reports. The api server merges the
+// applied fields with the live object, so the request doesn't carry a resource version.
+// Changes to immutable fields fail, as the object has to be recreated to change them.
+// It returns the live object before the request, nil when it didn't exist, and the applied object.
+// A dry run of an object that doesn't exist yet is skipped and returns a nil applied object,
+// as its namespace might not exist yet either.
+func (c *K8s) upsert(resource runtime.Object, dryRun bool) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	u, client, err := c.resourceClient(resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.setInventoryLabel(u)
+
+	live, err := client.Get(c.ctx, u.GetName(), apiMetaV1.GetOptions{})
+	switch {
+	case apiErrors.IsNotFound(err):
+		live = nil
+		if dryRun {
+			return nil, nil, nil
+		}
+	case err != nil:
+		return nil, nil, fmt.Errorf("getting live object - kind: %v, name: %v: %w", u.GetKind(), u.GetName(), err)
+	}
+
+	opts := apiMetaV1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}
+	if dryRun {
+		opts.DryRun = []string{apiMetaV1.DryRunAll}
+	}
+	applied, err := client.Apply(c.ctx, u.GetName(), u, opts)
+	if immutableFieldChanged(err) {
+		return nil, nil, fmt.Errorf("resource apply failed - kind: %v, name: %v: an immutable field changed, delete the object to recreate it: %w", u.GetKind(), u.GetName(), err)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("resource apply failed - kind: %v, name: %v: %w", u.GetKind(), u.GetName(), err)
+	}
+	return live, applied, nil
+}
+
+// immutableFieldChanged reports whether the api server rejected a request because it changes an immutable field.
+func immutableFieldChanged(err error) bool {
+	var status apiErrors.APIStatus
+	if !apiErrors.IsInvalid(err) || !errors.As(err, &status) || status.Status().Details == nil {
+		return false
+	}
+	for _, cause := range status.Status().Details.Causes {
+		if strings.Contains(cause.Message, "field is immutable") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	apiServerExtensionsClient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apiServerExtensionsScheme "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/scheme"
+	"k8s.io/apimachinery/pkg/api/meta"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/applyconfigurations"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+// builtinMapper resolves the scope of the built-in kinds without a cluster.
+// Custom kinds aren't known to it, as only the cluster serving them or their CustomResourceDefinition knows their scope.
+var builtinMapper = sync.OnceValue(func() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	addClientsetKinds(mapper, reflect.TypeFor[kubernetes.Interface](), scheme.Scheme)
+	addClientsetKinds(mapper, reflect.TypeFor[apiServerExtensionsClient.Interface](), apiServerExtensionsScheme.Scheme)
+	return mapper
+})
+
+// addClientsetKinds adds the kinds served by the typed clients of a clientset to the mapper.
+// The clients of namespaced resources take the namespace as their only argument,
+// e.g. CoreV1().ConfigMaps(namespace) but CoreV1().Namespaces().
+func addClientsetKinds(mapper *meta.DefaultRESTMapper, clientset reflect.Type, s *runtime.Scheme) {
+	for i := range clientset.NumMethod() {
+		group := clientset.Method(i).Type
+		if group.NumIn() != 0 || group.NumOut() != 1 || group.Out(0).Kind() != reflect.Interface {
+			continue
+		}
+		for j := range group.Out(0).NumMethod() {
+			client := group.Out(0).Method(j).Type
+			if client.NumOut() != 1 || client.Out(0).Kind() != reflect.Interface {
+				continue
+			}
+			// The kind is the type returned by Get, resources without one like TokenReview are left out.
+			get, ok := client.Out(0).MethodByName("Get")
+			if !ok || get.Type.NumOut() != 2 || get.Type.Out(0).Kind() != reflect.Pointer {
+				continue
+			}
+			obj, ok := reflect.New(get.Type.Out(0).Elem()).Interface().(runtime.Object)
+			if !ok {
+				continue
+			}
+			gvks, _, err := s.ObjectKinds(obj)
+			if err != nil {
+				continue
+			}
+			scope := meta.RESTScopeRoot
+			if client.NumIn() == 1 && client.In(0).Kind() == reflect.String {
+				scope = meta.RESTScopeNamespace
+			}
+			for _, gvk := range gvks {
+				mapper.Add(gvk, scope)
+			}
+		}
+	}
+}
+
+// DiscoveryMapper returns a mapper with the kinds served by the cluster of the config,
+// including the custom resources installed in it.
+func DiscoveryMapper(config *clientcmdapi.Config) (meta.RESTMapper, error) {
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("k8s config error: %w", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("k8s discovery client error: %w", err)
+	}
+	groupResources, err := restmapper.GetAPIGroupResources(discoveryClient)
+	if err != nil {
+		return nil, fmt.Errorf("listing the api resources of the cluster: %w", err)
+	}
+	return restmapper.NewDiscoveryRESTMapper(groupResources), nil
+}
+
+// crdMapper returns a mapper with the kinds of the CustomResourceDefinitions in the documents,
+// as their custom resources are usually deployed together with them.
+func crdMapper(docs []*document) meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, d := range docs {
+		u, ok := d.obj.(*unstructured.Unstructured)
+		if !ok || u.GroupVersionKind().GroupKind() != (schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}) {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(u.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(u.Object, "spec", "names", "kind")
+		scope := meta.RESTScopeNamespace
+		if s, _, _ := unstructured.NestedString(u.Object, "spec", "scope"); s == "Cluster" {
+			scope = meta.RESTScopeRoot
+		}
+		// The v1beta1 definitions can have a single version instead of a list.
+		versions, _, _ := unstructured.NestedSlice(u.Object, "spec", "versions")
+		if version, _, _ := unstructured.NestedString(u.Object, "spec", "version"); version != "" {
+			versions = append(versions, map[string]interface{}{"name": version})
+		}
+		for _, v := range versions {
+			if v, ok := v.(map[string]interface{}); ok {
+				if name, ok := v["name"].(string); ok {
+					mapper.Add(schema.GroupVersionKind{Group: group, Version: name, Kind: kind}, scope)
+				}
+			}
+		}
+	}
+	return mapper
+}
+
+// typeConverter checks objects against the OpenAPI schemas of the built-in kinds bundled with client-go.
+// The schemas are only parsed when validating as that takes a while.
+var typeConverter = sync.OnceValue(func() managedfields.TypeConverter {
+	return applyconfigurations.NewTypeConverter(scheme.Scheme)
+})
+
+// ValidationError is a problem with a single yaml document of a deployment file.
+type ValidationError struct {
+	File string
+	// Document is the position of the document in the file, starting from 1.
+	Document int
+	Err      error
+	// Warning is set for problems that don't stop the file from being applied.
+	Warning bool
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v, document %d: %v", e.File, e.Document, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// document is a decoded yaml document of a deployment file.
+type document struct {
+	file string
+	// doc is the position of the document in the file, starting from 1.
+	doc  int
+	text []byte
+	obj  runtime.Object
+	err  error
+}
+
+// Validate checks every document of the parsed deployment files and returns all problems found.
+// Every document has to decode into a k8s object and built-in kinds have to match their OpenAPI schema.
+// Cluster-scoped kinds can't have a namespace, and namespaced objects that aren't in the same namespace
+// as the rest of their file get a warning, where objects without one are in the "default" namespace.
+// The scope of a kind comes from the mapper of the cluster when not nil, the CustomResourceDefinitions
+// in the files or the built-in kinds. Other custom resources are only checked for a kind and a name.
+func Validate(deploymentResource []provider.Resource, mapper meta.RESTMapper) []*ValidationError {
+	var docs []*document
+	for _, deployment := range deploymentResource {
+		for i, text := range provider.SplitDocuments(deployment.Content) {
+			obj, err := decodeObject(text)
+			docs = append(docs, &document{file: deployment.FileName, doc: i + 1, text: text, obj: obj, err: err})
+		}
+	}
+	scopes := meta.FirstHitRESTMapper{MultiRESTMapper: meta.MultiRESTMapper{crdMapper(docs), builtinMapper()}}
+	if mapper != nil {
+		scopes.MultiRESTMapper = append(meta.MultiRESTMapper{mapper}, scopes.MultiRESTMapper...)
+	}
+
+	var problems []*ValidationError
+	// seen records where each object is declared to find duplicates across files.
+	seen := map[objectKey]*ValidationError{}
+	// fileNamespaces records the first namespaced object of each file.
+	type fileNamespace struct {
+		namespace string
+		doc       int
+	}
+	fileNamespaces := map[string]fileNamespace{}
+
+	for _, d := range docs {
+		report := func(warning bool, format string, args ...interface{}) {
+			problems = append(problems, &ValidationError{File: d.file, Document: d.doc, Err: fmt.Errorf(format, args...), Warning: warning})
+		}
+
+		if d.err != nil {
+			report(false, "decoding failed: %w", d.err)
+			continue
+		}
+		if d.obj == nil {
+			continue
+		}
+		gvk := d.obj.GetObjectKind().GroupVersionKind()
+		obj, ok := d.obj.(apiMetaV1.Object)
+		if !ok {
+			report(false, "kind %v has no metadata", gvk.Kind)
+			continue
+		}
+		if obj.GetName() == "" {
+			report(false, "%v has no name", gvk.Kind)
+		}
+
+		if scheme.Scheme.Recognizes(gvk) {
+			if err := validateSchema(d.text); err != nil {
+				report(false, "%v/%v doesn't match the %v schema: %w", gvk.Kind, obj.GetName(), gvk.GroupVersion(), err)
+			}
+		}
+
+		namespace := obj.GetNamespace()
+		mapping, err := scopes.RESTMapping(gvk.GroupKind(), gvk.Version)
+		switch {
+		case err != nil:
+			// The namespace of an unknown custom resource is only checked by the cluster.
+		case mapping.Scope.Name() == meta.RESTScopeNameRoot:
+			if namespace != "" {
+				report(false, "%v/%v is cluster-scoped but has namespace %q", gvk.Kind, obj.GetName(), namespace)
+			}
+		default:
+			if namespace == "" {
+				namespace = "default"
+			}
+			first, ok := fileNamespaces[d.file]
+			if !ok {
+				fileNamespaces[d.file] = fileNamespace{namespace, d.doc}
+			} else if namespace != first.namespace {
+				report(true, "%v/%v is in namespace %q while document %d is in namespace %q", gvk.Kind, obj.GetName(), namespace, first.doc, first.namespace)
+			}
+		}
+
+		key := objectKey{gvk.GroupKind(), namespace, obj.GetName()}
+		if first, ok := seen[key]; ok {
+			report(false, "%v/%v is already declared in %v, document %d", gvk.Kind, obj.GetName(), first.File, first.Document)
+			continue
+		}
+		seen[key] = &ValidationError{File: d.file, Document: d.doc}
+	}
+	return problems
+}
+
+// validateSchema checks the document against the OpenAPI schema of its kind.
+// The typed objects can't be used for this as decoding them drops unknown fields.
+func validateSchema(text []byte) error {
+	data, err := yaml.ToJSON(text)
+	if err != nil {
+		return err
+	}
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	_, err = typeConverter().ObjectToTyped(u)
+	return err
+}
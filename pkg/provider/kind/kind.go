@@ -14,196 +14,615 @@
 package kind
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 
+	"github.com/google/go-cmp/cmp"
 	"gopkg.in/alecthomas/kingpin.v2"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes/scheme"
+	yamlGo "gopkg.in/yaml.v2"
+	apiCoreV1 "k8s.io/api/core/v1"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/util/homedir"
 	"sigs.k8s.io/kind/pkg/cluster"
+	"sigs.k8s.io/kind/pkg/cluster/nodes"
+	"sigs.k8s.io/kind/pkg/cluster/nodeutils"
 	"sigs.k8s.io/kind/pkg/cmd"
+	kindErrors "sigs.k8s.io/kind/pkg/errors"
+	kindExec "sigs.k8s.io/kind/pkg/exec"
+	sigsYaml "sigs.k8s.io/yaml"
 
 	"github.com/prometheus/test-infra/pkg/provider"
-	k8sProvider "github.com/prometheus/test-infra/pkg/provider/k8s"
 )
 
+// Resource is a parsed KIND deployment file, an alias of provider.Resource.
 type Resource = provider.Resource
 
+func init() {
+	provider.Register(provider.Registration{
+		Name: "kind",
+		Help: `Kubernetes In Docker (KIND) provider - https://kind.sigs.k8s.io/docs/user/quick-start/`,
+		DefaultVars: map[string]string{
+			"NGINX_SERVICE_TYPE":        "NodePort",
+			"LOADGEN_SCALE_UP_REPLICAS": "2",
+		},
+		RequiredVars: []string{"CLUSTER_NAME"},
+		New:          func(ctx context.Context) provider.Provider { return New(ctx) },
+	})
+}
+
 // KIND holds the fields used to generate an API request.
 type KIND struct {
-	// The k8s provider used when we work with the manifest files.
-	k8sProvider *k8sProvider.K8s
 	// The kind provider used to instantiate a new provider.
 	kindProvider *cluster.Provider
-	// Final DeploymentFiles files.
-	DeploymentFiles []string
 	// Final DeploymentVars.
 	DeploymentVars map[string]string
-	// DeployResource to construct DeploymentVars and DeploymentFiles
-	DeploymentResource *provider.DeploymentResource
-	// Content bytes after parsing the template variables, grouped by filename.
-	kindResources []Resource
-	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
-	k8sResources []k8sProvider.Resource
+
+	// Images are the image archives of the images load command.
+	Images []string
+	// Recreate deletes an existing cluster with a different config before creating it.
+	Recreate bool
+	// The k8s client used to label the nodes of the emulated node pools.
+	// Set by the tests to use a fake k8s API.
+	clientK8s kubernetes.Interface
 
 	ctx context.Context
-	// KIND kuberconfig file
+	// The kubeconfig file KIND writes the cluster to.
+	// Defaults to a file of its own for every cluster, see kubeconfigPath.
 	kubeconfig string
 }
 
 // New is the KIND constructor.
-func New(dr *provider.DeploymentResource) *KIND {
+// All API requests and waits are aborted when ctx is cancelled.
+func New(ctx context.Context) *KIND {
 	return &KIND{
-		DeploymentResource: dr,
 		kindProvider: cluster.NewProvider(
 			cluster.ProviderWithLogger(cmd.NewLogger()),
 		),
-		ctx:        context.Background(),
-		kubeconfig: homedir.HomeDir() + "/.kube/config",
+		ctx: ctx,
 	}
 }
 
-// SetupDeploymentResources Sets up DeploymentVars and DeploymentFiles
-func (c *KIND) SetupDeploymentResources(*kingpin.ParseContext) error {
-	customDeploymentVars := map[string]string{
-		"NGINX_SERVICE_TYPE":        "NodePort",
-		"LOADGEN_SCALE_UP_REPLICAS": "2",
-	}
+// configPath is the file on the control plane nodes that ClusterCreate saves the kind config of the cluster to,
+// to compare it with the config of later creates.
+const configPath = "/kind/infra-config.yaml"
 
-	c.DeploymentFiles = c.DeploymentResource.DeploymentFiles
-	c.DeploymentVars = provider.MergeDeploymentVars(
-		c.DeploymentResource.DefaultDeploymentVars,
-		customDeploymentVars,
-		c.DeploymentResource.FlagDeploymentVars,
-	)
-	return nil
+// kubeconfigPath returns the default kubeconfig file of a cluster.
+// Every cluster gets a file of its own, so that creating and deleting clusters leaves
+// the contexts of ~/.kube/config and of other clusters alone.
+func kubeconfigPath(clusterName string) string {
+	return filepath.Join(homedir.HomeDir(), ".kube", "kind-"+clusterName+".yaml")
 }
 
-// KINDDeploymentsParse parses the environment/kind deployment files and saves the result as bytes grouped by the filename.
-// Any DeploymentVar will be replaced in the resources files following the golang text template format.
-func (c *KIND) KINDDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
+// Flags registers the kubeconfig and recreate flags, KIND needs no credentials.
+func (c *KIND) Flags(cmd *kingpin.CmdClause) {
+	cmd.Flag("kubeconfig", "kubeconfig file the cluster is written to and read from. If not set the tool will use ~/.kube/kind-$CLUSTER_NAME.yaml.").
+		PlaceHolder("FILE").
+		StringVar(&c.kubeconfig)
+	cmd.Flag("recreate", "When cluster create finds an existing cluster with a different config, delete it and create it again instead of failing.").
+		BoolVar(&c.Recreate)
+}
 
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		return err
+// Init sets the deployment variables and the kubeconfig file, KIND needs no API client.
+func (c *KIND) Init(deploymentVars map[string]string) error {
+	c.DeploymentVars = deploymentVars
+	if c.kubeconfig == "" {
+		c.kubeconfig = kubeconfigPath(c.DeploymentVars["CLUSTER_NAME"])
 	}
-	c.kindResources = deploymentResource
 	return nil
 }
 
-func (c *KIND) K8SDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
+// Commands adds the images commands.
+func (c *KIND) Commands(cmd *kingpin.CmdClause, setup kingpin.Action, run func(func([]Resource) error) kingpin.Action) {
+	images := cmd.Command("images", "manage the images of KIND clusters").
+		Action(setup)
+	load := images.Command("load", "kind images load -f FileOrFolder -v CLUSTER_NAME:$CLUSTER_NAME --image=prometheus.tar").
+		Action(run(c.ImagesLoad))
+	load.Flag("image", "docker or OCI image archive to load into all nodes, e.g. written by docker save. Can be repeated and adds to the images list of the kind manifest.").
+		PlaceHolder("ARCHIVE").
+		StringsVar(&c.Images)
+}
 
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		return err
-	}
-	for _, deployment := range deploymentResource {
-		decode := scheme.Codecs.UniversalDeserializer().Decode
-		k8sObjects := make([]runtime.Object, 0)
+// ClusterCreate creates a new cluster, or reuses an existing cluster with the same config.
+// An existing cluster with a different config is an error, unless Recreate is set.
+// The images listed in the kind manifest are loaded into the nodes of the cluster.
+func (c *KIND) ClusterCreate(deployments []Resource) error {
+	name := c.DeploymentVars["CLUSTER_NAME"]
+	for _, deployment := range deployments {
+		config, images, err := splitImages(deployment)
+		if err != nil {
+			return err
+		}
 
-		for _, text := range strings.Split(string(deployment.Content), provider.Separator) {
-			text = strings.TrimSpace(text)
-			if len(text) == 0 {
-				continue
+		reuse, err := c.reuseCluster(name, config)
+		if err != nil {
+			return err
+		}
+		if reuse {
+			log.Printf("Reusing cluster '%v' with the same config", name)
+			if err := c.kindProvider.ExportKubeConfig(name, c.kubeconfig, false); err != nil {
+				return fmt.Errorf("exporting the kubeconfig of cluster %v: %w", name, err)
 			}
+		} else {
+			CreateWithConfigFile := cluster.CreateWithRawConfig(config)
 
-			resource, _, err := decode([]byte(text), nil, nil)
+			err = c.kindProvider.Create(name, CreateWithConfigFile, cluster.CreateWithKubeconfigPath(c.kubeconfig))
 			if err != nil {
-				return fmt.Errorf("decoding the resource file:%v, section:%v...: %w", deployment.FileName, text[:100], err)
+				return err
 			}
-			if resource == nil {
-				continue
+			if err := c.saveConfig(name, config); err != nil {
+				return err
 			}
-			k8sObjects = append(k8sObjects, resource)
 		}
-		if len(k8sObjects) > 0 {
-			c.k8sResources = append(c.k8sResources, k8sProvider.Resource{FileName: deployment.FileName, Objects: k8sObjects})
+		if err := c.loadImages(images); err != nil {
+			return err
 		}
+		// The worker nodes get no node pool labels from the kind config, so workloads selecting
+		// a node pool stay pending until its nodes are labelled.
+		log.Printf("Cluster '%v' is written to kubeconfig %v, label its worker nodes for the node pools with `infra kind nodes create`", name, c.kubeconfig)
 	}
 	return nil
 }
 
-// checkDeploymentVarsAndFiles checks whether the requied deployment vars are passed.
-func (c *KIND) checkDeploymentVarsAndFiles() error {
-	reqDepVars := []string{"CLUSTER_NAME"}
-	for _, k := range reqDepVars {
-		if v, ok := c.DeploymentVars[k]; !ok || v == "" {
-			return fmt.Errorf("missing required %v variable", k)
+// reuseCluster returns whether an existing cluster has the same config.
+// It deletes an existing cluster with a different config when Recreate is set, or returns an error with the diff.
+func (c *KIND) reuseCluster(name string, config []byte) (bool, error) {
+	clusters, err := c.kindProvider.List()
+	if err != nil {
+		return false, fmt.Errorf("listing clusters: %w", err)
+	}
+	if !slices.Contains(clusters, name) {
+		return false, nil
+	}
+
+	saved, err := c.savedConfig(name)
+	if err != nil {
+		return false, err
+	}
+	var diff string
+	if saved != nil {
+		if diff, err = configDiff(saved, config); err != nil {
+			return false, err
+		}
+		if diff == "" {
+			return true, nil
+		}
+	}
+
+	switch {
+	case c.Recreate:
+		log.Printf("Deleting cluster '%v' to create it again", name)
+		if err := c.kindProvider.Delete(name, c.kubeconfig); err != nil {
+			return false, err
 		}
+		return false, nil
+	case saved == nil:
+		return false, fmt.Errorf("cluster %v already exists, but wasn't created by infra, pass --recreate to delete it and create it again", name)
+	default:
+		return false, fmt.Errorf("cluster %v already exists with a different config, pass --recreate to delete it and create it again (-existing +new):\n%v", name, diff)
+	}
+}
+
+// configDiff returns the differences between two kind configs, ignoring their formatting.
+func configDiff(a, b []byte) (string, error) {
+	var configA, configB interface{}
+	if err := sigsYaml.Unmarshal(a, &configA); err != nil {
+		return "", fmt.Errorf("decoding the saved kind config: %w", err)
+	}
+	if err := sigsYaml.Unmarshal(b, &configB); err != nil {
+		return "", fmt.Errorf("decoding the kind config: %w", err)
+	}
+	return cmp.Diff(configA, configB), nil
+}
+
+// saveConfig saves the kind config of a new cluster on its control plane nodes.
+func (c *KIND) saveConfig(name string, config []byte) error {
+	controlPlanes, err := c.controlPlaneNodes(name)
+	if err != nil {
+		return err
 	}
-	if len(c.DeploymentFiles) == 0 {
-		return fmt.Errorf("missing deployment file(s)")
+	for _, node := range controlPlanes {
+		if err := nodeutils.WriteFile(node, configPath, string(config)); err != nil {
+			return fmt.Errorf("saving the kind config on node %v: %w", node, err)
+		}
 	}
 	return nil
 }
 
-// ClusterCreate create a new cluster or applies changes to an existing cluster.
-func (c *KIND) ClusterCreate(*kingpin.ParseContext) error {
-	for _, deployment := range c.kindResources {
-		CreateWithConfigFile := cluster.CreateWithRawConfig(deployment.Content)
+// savedConfig returns the kind config saved on the control plane nodes of a cluster,
+// or nil for clusters that weren't created by infra.
+func (c *KIND) savedConfig(name string) ([]byte, error) {
+	controlPlanes, err := c.controlPlaneNodes(name)
+	if err != nil {
+		return nil, err
+	}
+	return readSavedConfig(controlPlanes[0])
+}
+
+// readSavedConfig reads the kind config saved on the node, or returns nil when the file doesn't exist.
+// Other failures, like a stopped node, are errors so that the cluster isn't taken for one created without infra.
+func readSavedConfig(node nodes.Node) ([]byte, error) {
+	var config, stderr bytes.Buffer
+	err := node.Command("cat", configPath).SetStdout(&config).SetStderr(&stderr).Run()
+	if err == nil {
+		return config.Bytes(), nil
+	}
+	// cat exits with status 1 and reports ENOENT for a missing file.
+	var exitErr interface{ ExitCode() int }
+	if runErr := kindExec.RunErrorForError(err); runErr != nil && errors.As(runErr.Inner, &exitErr) &&
+		exitErr.ExitCode() == 1 && bytes.Contains(stderr.Bytes(), []byte("No such file or directory")) {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("reading the saved kind config on node %v: %w: %s", node, err, bytes.TrimSpace(stderr.Bytes()))
+}
+
+func (c *KIND) controlPlaneNodes(name string) ([]nodes.Node, error) {
+	clusterNodes, err := c.kindProvider.ListInternalNodes(name)
+	if err != nil {
+		return nil, fmt.Errorf("listing the nodes of cluster %v: %w", name, err)
+	}
+	controlPlanes, err := nodeutils.ControlPlaneNodes(clusterNodes)
+	if err != nil {
+		return nil, fmt.Errorf("listing the control plane nodes of cluster %v: %w", name, err)
+	}
+	if len(controlPlanes) == 0 {
+		return nil, fmt.Errorf("no control plane nodes found for cluster %v", name)
+	}
+	return controlPlanes, nil
+}
 
-		err := c.kindProvider.Create(c.DeploymentVars["CLUSTER_NAME"], CreateWithConfigFile)
+// ImagesLoad loads the image archives of the images flag and of the images lists
+// of the kind manifests into all nodes of an existing cluster.
+func (c *KIND) ImagesLoad(deployments []Resource) error {
+	images := c.Images
+	for _, deployment := range deployments {
+		_, manifestImages, err := splitImages(deployment)
 		if err != nil {
 			return err
 		}
+		images = append(images, manifestImages...)
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("no images to load, set the image flag or the images list of the kind manifest")
+	}
+	return c.loadImages(images)
+}
+
+// loadImages loads image archives into all nodes of the cluster, so that pods can use the images without pulling them.
+func (c *KIND) loadImages(images []string) error {
+	if len(images) == 0 {
+		return nil
+	}
+	name := c.DeploymentVars["CLUSTER_NAME"]
+	clusterNodes, err := c.kindProvider.ListInternalNodes(name)
+	if err != nil {
+		return fmt.Errorf("listing the nodes of cluster %v: %w", name, err)
+	}
+	if len(clusterNodes) == 0 {
+		return fmt.Errorf("no nodes found for cluster %v", name)
+	}
+
+	for _, image := range images {
+		fns := []func() error{}
+		for _, node := range clusterNodes {
+			fns = append(fns, func() error { return loadImage(image, node) })
+		}
+		if err := kindErrors.UntilErrorConcurrent(fns); err != nil {
+			return fmt.Errorf("loading image archive %v into cluster %v: %w", image, name, err)
+		}
 	}
 	return nil
 }
 
-// ClusterDelete deletes a k8s cluster.
-func (c *KIND) ClusterDelete(*kingpin.ParseContext) error {
-	err := c.kindProvider.Delete(c.DeploymentVars["CLUSTER_NAME"], c.kubeconfig)
+func loadImage(image string, node nodes.Node) error {
+	f, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.Printf("Loading image archive %v into node %v", image, node)
+	return nodeutils.LoadImageArchive(node, f)
+}
+
+// splitImages splits the images list off a kind manifest, as it isn't part of the KIND cluster config.
+func splitImages(deployment Resource) ([]byte, []string, error) {
+	manifest := map[string]interface{}{}
+	if err := sigsYaml.Unmarshal(deployment.Content, &manifest); err != nil {
+		return nil, nil, &provider.DecodeError{File: deployment.FileName, Err: err}
+	}
+	if _, ok := manifest["images"]; !ok {
+		return deployment.Content, nil, nil
+	}
+
+	var images struct {
+		Images []string `json:"images"`
+	}
+	if err := sigsYaml.Unmarshal(deployment.Content, &images); err != nil {
+		return nil, nil, &provider.DecodeError{File: deployment.FileName, Err: err}
+	}
+	delete(manifest, "images")
+	config, err := sigsYaml.Marshal(manifest)
+	if err != nil {
+		return nil, nil, &provider.DecodeError{File: deployment.FileName, Err: err}
+	}
+	return config, images.Images, nil
+}
+
+// ClusterDelete deletes a k8s cluster and removes it from the kubeconfig file.
+// The default kubeconfig file of the cluster is removed altogether.
+func (c *KIND) ClusterDelete([]Resource) error {
+	name := c.DeploymentVars["CLUSTER_NAME"]
+	err := c.kindProvider.Delete(name, c.kubeconfig)
 	if err != nil {
 		return err
 	}
+	if c.kubeconfig == kubeconfigPath(name) {
+		if err := os.Remove(c.kubeconfig); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing kubeconfig file: %w", err)
+		}
+	}
 	return nil
 }
 
-// NewK8sProvider sets the k8s provider used for deploying k8s manifests.
-func (c *KIND) NewK8sProvider(*kingpin.ParseContext) error {
-	var err error
-	apiConfig, err := clientcmd.LoadFromFile(c.kubeconfig)
+// gkeNodePools is the part of a GKE nodes file KIND uses to emulate the node pools,
+// by labelling its worker nodes with the node pool labels.
+type gkeNodePools struct {
+	Cluster struct {
+		NodePools []nodePool `yaml:"nodepools"`
+	} `yaml:"cluster"`
+}
+
+type nodePool struct {
+	Name             string `yaml:"name"`
+	InitialNodeCount int    `yaml:"initialnodecount"`
+	Config           struct {
+		Labels map[string]string `yaml:"labels"`
+	} `yaml:"config"`
+}
+
+// nodeCount returns the number of nodes of the node pool, at least one.
+func (p nodePool) nodeCount() int {
+	return max(p.InitialNodeCount, 1)
+}
+
+// matches returns whether a node has all labels of the node pool.
+func (p nodePool) matches(node apiCoreV1.Node) bool {
+	for k, v := range p.Config.Labels {
+		if node.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// free returns whether a node is a worker without any of the node pool label keys,
+// so that it can be labelled for the node pool.
+func (p nodePool) free(node apiCoreV1.Node) bool {
+	if _, ok := node.Labels["node-role.kubernetes.io/control-plane"]; ok {
+		return false
+	}
+	for k := range p.Config.Labels {
+		if _, ok := node.Labels[k]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeNodePools decodes the node pools of GKE nodes files, ignoring the GKE specific fields.
+func decodeNodePools(deployments []Resource) ([]nodePool, error) {
+	var pools []nodePool
+	for _, deployment := range deployments {
+		nodes := &gkeNodePools{}
+		if err := yamlGo.Unmarshal(deployment.Content, nodes); err != nil {
+			return nil, &provider.DecodeError{File: deployment.FileName, Err: err}
+		}
+		for _, pool := range nodes.Cluster.NodePools {
+			if len(pool.Config.Labels) == 0 {
+				return nil, &provider.DecodeError{File: deployment.FileName, Err: fmt.Errorf("node pool %v has no labels to select its nodes", pool.Name)}
+			}
+		}
+		pools = append(pools, nodes.Cluster.NodePools...)
+	}
+	return pools, nil
+}
+
+// nodes returns the nodes of the cluster sorted by name.
+func (c *KIND) nodes() ([]apiCoreV1.Node, error) {
+	if c.clientK8s == nil {
+		config, err := c.KubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("k8s config error: %w", err)
+		}
+		if c.clientK8s, err = kubernetes.NewForConfig(restConfig); err != nil {
+			return nil, fmt.Errorf("k8s client error: %w", err)
+		}
+	}
+
+	list, err := c.clientK8s.CoreV1().Nodes().List(c.ctx, apiMetaV1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	return list.Items, nil
+}
+
+// labelNode sets the labels of a node, a nil value removes the label.
+func (c *KIND) labelNode(node *apiCoreV1.Node, labels map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}})
 	if err != nil {
 		return err
 	}
+	rep, err := c.clientK8s.CoreV1().Nodes().Patch(c.ctx, node.Name, types.MergePatchType, patch, apiMetaV1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("labelling node %v: %w", node.Name, err)
+	}
+	*node = *rep
+	return nil
+}
 
-	c.k8sProvider, err = k8sProvider.New(c.ctx, apiConfig)
+// NodePoolCreate emulates the node pools of GKE nodes files by labelling free worker nodes
+// with the node pool labels, until every node pool has its initial node count of nodes.
+// The workers must be part of the kind cluster config.
+func (c *KIND) NodePoolCreate(deployments []Resource) error {
+	pools, err := decodeNodePools(deployments)
+	if err != nil {
+		return err
+	}
+	nodes, err := c.nodes()
 	if err != nil {
 		return err
 	}
+
+	for _, pool := range pools {
+		var have int
+		var free []int
+		for i, node := range nodes {
+			switch {
+			case pool.matches(node):
+				have++
+			case pool.free(node):
+				free = append(free, i)
+			}
+		}
+		need := pool.nodeCount() - have
+		if need > len(free) {
+			return fmt.Errorf("creating nodepool %v: %v more worker nodes needed, but only %v are free, add workers to the kind cluster config", pool.Name, need, len(free))
+		}
+
+		labels := map[string]*string{}
+		for k, v := range pool.Config.Labels {
+			labels[k] = &v
+		}
+		for _, i := range free[:max(need, 0)] {
+			log.Printf("Labelling node '%v' for nodepool '%v'", nodes[i].Name, pool.Name)
+			if err := c.labelNode(&nodes[i], labels); err != nil {
+				return fmt.Errorf("creating nodepool %v: %w", pool.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
-// ResourceApply calls k8s.ResourceApply to apply the k8s objects in the manifest files.
-func (c *KIND) ResourceApply(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceApply(c.k8sResources, true); err != nil {
+// NodePoolDelete removes the node pool labels from the nodes of the emulated node pools,
+// so that the nodes are free for other node pools.
+func (c *KIND) NodePoolDelete(deployments []Resource) error {
+	pools, err := decodeNodePools(deployments)
+	if err != nil {
 		return err
 	}
+	nodes, err := c.nodes()
+	if err != nil {
+		return err
+	}
+
+	for _, pool := range pools {
+		labels := map[string]*string{}
+		for k := range pool.Config.Labels {
+			labels[k] = nil
+		}
+		for i := range nodes {
+			if !pool.matches(nodes[i]) {
+				continue
+			}
+			log.Printf("Removing the labels of nodepool '%v' from node '%v'", pool.Name, nodes[i].Name)
+			if err := c.labelNode(&nodes[i], labels); err != nil {
+				return fmt.Errorf("deleting nodepool %v: %w", pool.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
-// ResourceDelete calls k8s.ResourceDelete to apply the k8s objects in the manifest files.
-func (c *KIND) ResourceDelete(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceDelete(c.k8sResources); err != nil {
+// NodePoolsRunning returns an error if at least one node of the cluster isn't ready
+// or at least one emulated node pool doesn't have its initial node count of nodes.
+func (c *KIND) NodePoolsRunning(deployments []Resource) error {
+	pools, err := decodeNodePools(deployments)
+	if err != nil {
 		return err
 	}
+	nodes, err := c.nodes()
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if !nodeReady(node) {
+			return fmt.Errorf("node not ready name: %v", node.Name)
+		}
+	}
+	for _, pool := range pools {
+		var have int
+		for _, node := range nodes {
+			if pool.matches(node) {
+				have++
+			}
+		}
+		if have < pool.nodeCount() {
+			return fmt.Errorf("nodepool not running name: %v, %v of %v nodes labelled", pool.Name, have, pool.nodeCount())
+		}
+	}
 	return nil
 }
 
-// GetDeploymentVars shows deployment variables.
-func (c *KIND) GetDeploymentVars(_ *kingpin.ParseContext) error {
-	fmt.Print("-------------------\n   DeploymentVars   \n------------------- \n")
-	for key, value := range c.DeploymentVars {
-		fmt.Println(key, ": ", value)
+// NodePoolsDeleted returns an error if at least one node still has the labels of an emulated node pool.
+func (c *KIND) NodePoolsDeleted(deployments []Resource) error {
+	pools, err := decodeNodePools(deployments)
+	if err != nil {
+		return err
+	}
+	nodes, err := c.nodes()
+	if err != nil {
+		return err
+	}
+
+	for _, pool := range pools {
+		for _, node := range nodes {
+			if pool.matches(node) {
+				return fmt.Errorf("nodepool running name: %v, node: %v", pool.Name, node.Name)
+			}
+		}
 	}
 	return nil
 }
+
+func nodeReady(node apiCoreV1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == apiCoreV1.NodeReady {
+			return cond.Status == apiCoreV1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// KubeConfig returns the config of the kubeconfig file KIND writes the cluster to,
+// with the context of the cluster selected.
+func (c *KIND) KubeConfig() (*clientcmdapi.Config, error) {
+	config, err := clientcmd.LoadFromFile(c.kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	// KIND names the context of a cluster after the cluster with a kind- prefix.
+	kindContext := "kind-" + c.DeploymentVars["CLUSTER_NAME"]
+	if _, ok := config.Contexts[kindContext]; !ok {
+		return nil, fmt.Errorf("context %v not found in kubeconfig %v", kindContext, c.kubeconfig)
+	}
+	config.CurrentContext = kindContext
+	return config, nil
+}
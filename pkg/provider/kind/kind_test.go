@@ -0,0 +1,364 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apiCoreV1 "k8s.io/api/core/v1"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+	"sigs.k8s.io/kind/pkg/cluster/nodes"
+	kindExec "sigs.k8s.io/kind/pkg/exec"
+	sigsYaml "sigs.k8s.io/yaml"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+const kindConfig = `kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+nodes:
+  - role: control-plane
+    kubeadmConfigPatches:
+      - |
+        kind: InitConfiguration
+        nodeRegistration:
+          kubeletExtraArgs:
+            node-labels: "node-name=main-node"
+`
+
+func TestSplitImages(t *testing.T) {
+	config, images, err := splitImages(Resource{FileName: "cluster_kind.yaml", Content: []byte(kindConfig)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != kindConfig || images != nil {
+		t.Errorf("expected a manifest without images to be left as is, got %q, %v", config, images)
+	}
+
+	config, images, err = splitImages(Resource{FileName: "cluster_kind.yaml", Content: []byte(kindConfig + "images:\n  - prometheus.tar\n  - loadgen.tar\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"prometheus.tar", "loadgen.tar"}; !reflect.DeepEqual(images, exp) {
+		t.Errorf("expected images %v, got %v", exp, images)
+	}
+	// The remaining config must pass the strict decoding of KIND.
+	cluster := &v1alpha4.Cluster{}
+	if err := sigsYaml.UnmarshalStrict(config, cluster); err != nil {
+		t.Fatalf("decoding the config without images: %v", err)
+	}
+	if len(cluster.Nodes) != 1 || !strings.Contains(cluster.Nodes[0].KubeadmConfigPatches[0], "node-name=main-node") {
+		t.Errorf("unexpected config %+v", cluster)
+	}
+
+	_, _, err = splitImages(Resource{FileName: "cluster_kind.yaml", Content: []byte(kindConfig + "images: prometheus.tar\n")})
+	var decodeErr *provider.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Errorf("expected a DecodeError for an images string, got %v", err)
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	// Formatting changes aren't differences.
+	reformatted := strings.ReplaceAll(kindConfig, "  - role", "- role")
+	reformatted = strings.ReplaceAll(reformatted, "\n    kubeadmConfigPatches", "\n  kubeadmConfigPatches")
+	reformatted = strings.ReplaceAll(reformatted, "\n      - |", "\n    - |")
+	diff, err := configDiff([]byte(kindConfig), []byte(reformatted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff, got:\n%v\nfor:\n%v", diff, reformatted)
+	}
+
+	diff, err = configDiff([]byte(kindConfig), []byte(kindConfig+"  - role: worker\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "worker") {
+		t.Errorf("expected a diff with the new worker, got:\n%v", diff)
+	}
+}
+
+// fakeNode runs every command with the same result, like docker exec on a kind node.
+type fakeNode struct {
+	nodes.Node
+	stdout, stderr string
+	exitCode       int
+}
+
+func (n *fakeNode) String() string { return "prombench-control-plane" }
+
+func (n *fakeNode) Command(name string, args ...string) kindExec.Cmd {
+	return &fakeCmd{node: n, command: append([]string{name}, args...)}
+}
+
+type fakeCmd struct {
+	kindExec.Cmd
+	node           *fakeNode
+	command        []string
+	stdout, stderr io.Writer
+}
+
+func (c *fakeCmd) SetStdout(w io.Writer) kindExec.Cmd { c.stdout = w; return c }
+func (c *fakeCmd) SetStderr(w io.Writer) kindExec.Cmd { c.stderr = w; return c }
+
+func (c *fakeCmd) Run() error {
+	io.WriteString(c.stdout, c.node.stdout)
+	io.WriteString(c.stderr, c.node.stderr)
+	if c.node.exitCode != 0 {
+		return &kindExec.RunError{Command: c.command, Inner: exitError(c.node.exitCode)}
+	}
+	return nil
+}
+
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+func TestReadSavedConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		node   fakeNode
+		exp    string
+		expErr bool
+	}{
+		{
+			name: "saved",
+			node: fakeNode{stdout: kindConfig},
+			exp:  kindConfig,
+		},
+		{
+			name: "missing file",
+			node: fakeNode{stderr: "cat: /kind/infra-config.yaml: No such file or directory\n", exitCode: 1},
+		},
+		{
+			name:   "stopped node",
+			node:   fakeNode{stderr: "Error response from daemon: container is not running\n", exitCode: 1},
+			expErr: true,
+		},
+		{
+			name:   "permission denied",
+			node:   fakeNode{stderr: "cat: /kind/infra-config.yaml: Permission denied\n", exitCode: 1},
+			expErr: true,
+		},
+		{
+			name:   "exec failure",
+			node:   fakeNode{stderr: "OCI runtime exec failed\n", exitCode: 126},
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := readSavedConfig(&tc.node)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("expected an error, got config %q", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(config) != tc.exp {
+				t.Errorf("expected config %q, got %q", tc.exp, config)
+			}
+		})
+	}
+}
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: kind-prombench
+  cluster: {server: https://127.0.0.1:6443}
+- name: kind-other
+  cluster: {server: https://127.0.0.1:7443}
+contexts:
+- name: kind-prombench
+  context: {cluster: kind-prombench, user: kind-prombench}
+- name: kind-other
+  context: {cluster: kind-other, user: kind-other}
+current-context: kind-other
+users:
+- name: kind-prombench
+  user: {token: t}
+- name: kind-other
+  user: {token: t}
+`
+
+func TestKubeConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// Every cluster has a kubeconfig file of its own by default.
+	c := New(context.Background())
+	if err := c.Init(map[string]string{"CLUSTER_NAME": "prombench"}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := filepath.Join(home, ".kube", "kind-prombench.yaml"); c.kubeconfig != exp {
+		t.Errorf("expected kubeconfig %v, got %v", exp, c.kubeconfig)
+	}
+	if _, err := c.KubeConfig(); err == nil {
+		t.Error("expected an error for a missing kubeconfig file")
+	}
+
+	// The context of the cluster is selected in a shared kubeconfig file.
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c = New(context.Background())
+	c.kubeconfig = path
+	if err := c.Init(map[string]string{"CLUSTER_NAME": "prombench"}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := c.KubeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentContext != "kind-prombench" {
+		t.Errorf("expected the kind-prombench context, got %v", config.CurrentContext)
+	}
+
+	c.DeploymentVars["CLUSTER_NAME"] = "missing"
+	if _, err := c.KubeConfig(); err == nil {
+		t.Error("expected an error for a cluster without context")
+	}
+}
+
+func node(name string, ready bool, labels map[string]string) *apiCoreV1.Node {
+	status := apiCoreV1.ConditionFalse
+	if ready {
+		status = apiCoreV1.ConditionTrue
+	}
+	return &apiCoreV1.Node{
+		ObjectMeta: apiMetaV1.ObjectMeta{Name: name, Labels: labels},
+		Status:     apiCoreV1.NodeStatus{Conditions: []apiCoreV1.NodeCondition{{Type: apiCoreV1.NodeReady, Status: status}}},
+	}
+}
+
+var nodesFile = Resource{FileName: "nodes_gke.yaml", Content: []byte(`
+zone: europe-west3-a
+projectid: project
+cluster:
+  name: prombench
+  nodepools:
+  - name: prometheus-123
+    initialnodecount: 2
+    config:
+      machinetype: n1-highmem-8
+      labels:
+        isolation: prometheus
+        node-name: prometheus-123
+  - name: nodes-123
+    initialnodecount: 1
+    config:
+      labels:
+        isolation: none
+        node-name: nodes-123
+`)}
+
+func TestNodePools(t *testing.T) {
+	c := New(context.Background())
+	c.DeploymentVars = map[string]string{"CLUSTER_NAME": "prombench"}
+	c.clientK8s = fake.NewClientset(
+		node("prombench-control-plane", true, map[string]string{"node-role.kubernetes.io/control-plane": "", "node-name": "main-node"}),
+		node("prombench-worker", true, nil),
+		node("prombench-worker2", true, nil),
+		node("prombench-worker3", true, nil),
+	)
+
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for missing node pools")
+	}
+
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	// Creating the node pools again changes nothing.
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err == nil {
+		t.Fatal("expected an error for running node pools")
+	}
+	nodes, err := c.nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, n := range nodes {
+		labels = append(labels, n.Labels["node-name"])
+	}
+	if exp := []string{"main-node", "prometheus-123", "prometheus-123", "nodes-123"}; !reflect.DeepEqual(labels, exp) {
+		t.Errorf("expected node-name labels %v, got %v", exp, labels)
+	}
+
+	if err := c.NodePoolDelete([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsDeleted([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err = c.nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes[1].Labels) != 0 || nodes[0].Labels["node-name"] != "main-node" {
+		t.Errorf("expected only the node pool labels to be removed, got %v", nodes)
+	}
+}
+
+func TestNodePoolsNotReady(t *testing.T) {
+	c := New(context.Background())
+	c.DeploymentVars = map[string]string{"CLUSTER_NAME": "prombench"}
+	c.clientK8s = fake.NewClientset(
+		node("prombench-worker", false, nil),
+		node("prombench-worker2", true, nil),
+		node("prombench-worker3", true, nil),
+	)
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodePoolsRunning([]Resource{nodesFile}); err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("expected an error for a node that isn't ready, got %v", err)
+	}
+}
+
+func TestNodePoolsNotEnoughWorkers(t *testing.T) {
+	c := New(context.Background())
+	c.DeploymentVars = map[string]string{"CLUSTER_NAME": "prombench"}
+	c.clientK8s = fake.NewClientset(node("prombench-worker", true, nil))
+
+	if err := c.NodePoolCreate([]Resource{nodesFile}); err == nil || !strings.Contains(err.Error(), "add workers") {
+		t.Fatalf("expected an error for missing workers, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kubeconfig provides the k8s provider, which deploys to an existing cluster
+// selected with a kubeconfig file or the in-cluster config and doesn't manage any clusters.
+package kubeconfig
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/prometheus/test-infra/pkg/provider"
+)
+
+func init() {
+	provider.Register(provider.Registration{
+		Name: "k8s",
+		Help: "Existing k8s cluster selected with a kubeconfig file or the in-cluster config. Only manages k8s resources, not the cluster.",
+		New:  func(context.Context) provider.Provider { return New() },
+	})
+}
+
+// Kubeconfig holds the flags selecting the cluster.
+type Kubeconfig struct {
+	// Path is the kubeconfig file. When empty the KUBECONFIG env variable or ~/.kube/config is used.
+	Path string
+	// Context is the kubeconfig context to use instead of the current context.
+	Context string
+	// InCluster selects the config of the service account of the pod infra runs in.
+	InCluster bool
+}
+
+// New is the Kubeconfig constructor.
+func New() *Kubeconfig {
+	return &Kubeconfig{}
+}
+
+// Flags registers the flags selecting the cluster.
+func (c *Kubeconfig) Flags(cmd *kingpin.CmdClause) {
+	cmd.Flag("kubeconfig", "kubeconfig file of the cluster. If not set the tool will use the KUBECONFIG env variable or ~/.kube/config.").
+		PlaceHolder("FILE").
+		StringVar(&c.Path)
+	cmd.Flag("context", "kubeconfig context to use instead of the current context.").
+		StringVar(&c.Context)
+	cmd.Flag("in-cluster", "Use the service account of the pod the tool runs in instead of a kubeconfig file.").
+		BoolVar(&c.InCluster)
+}
+
+// Init checks that the flags don't select the cluster twice.
+func (c *Kubeconfig) Init(map[string]string) error {
+	if c.InCluster && (c.Path != "" || c.Context != "") {
+		return fmt.Errorf("--in-cluster can't be used with --kubeconfig or --context")
+	}
+	return nil
+}
+
+// KubeConfig loads the kubeconfig file with the selected context, or returns nil for the in-cluster config.
+func (c *Kubeconfig) KubeConfig() (*clientcmdapi.Config, error) {
+	if c.InCluster {
+		return nil, nil
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.Path
+	config, err := rules.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	if c.Context != "" {
+		if _, ok := config.Contexts[c.Context]; !ok {
+			return nil, fmt.Errorf("context %v not found in kubeconfig", c.Context)
+		}
+		config.CurrentContext = c.Context
+	}
+	if config.CurrentContext == "" {
+		return nil, fmt.Errorf("kubeconfig has no current context, select one with --context")
+	}
+	return config, nil
+}
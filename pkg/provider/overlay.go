@@ -0,0 +1,390 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	sigsYaml "sigs.k8s.io/yaml"
+)
+
+// OverlayFileName is the name of the file that turns a directory into an overlay of another deployment directory.
+const OverlayFileName = "overlay.yaml"
+
+// Overlay is a deployment directory made of the files of a base directory with patches applied to their objects.
+// All other yaml files in the overlay directory, except for the patches and the vars file, are added to the base files
+// or replace the base file with the same path relative to the base directory.
+type Overlay struct {
+	// Base is the deployment directory the overlay is based on, relative paths are relative to the overlay directory.
+	// It can be an overlay itself.
+	Base    string  `yaml:"base"`
+	Patches []Patch `yaml:"patches"`
+}
+
+// Patch is a strategic merge patch or a JSON patch (RFC 6902) of the objects selected by the target.
+type Patch struct {
+	// Path is the patch file relative to the overlay directory. It is templated like the deployment files.
+	// A file with a list of operations is a JSON patch, any other file is a strategic merge patch.
+	Path string `yaml:"path"`
+	// Target selects the objects to patch. It is required for JSON patches,
+	// strategic merge patches patch the object with the kind and name in the patch by default.
+	Target *PatchTarget `yaml:"target"`
+}
+
+// PatchTarget selects objects by their kind, name and namespace. Empty fields match any value
+// and the name and namespace can be shell patterns like prometheus-test-*.
+type PatchTarget struct {
+	Group     string `yaml:"group"`
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// objectMeta is the part of an object that patches are matched on.
+type objectMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+func (t *PatchTarget) String() string {
+	return fmt.Sprintf("group: %q, kind: %q, name: %q, namespace: %q", t.Group, t.Kind, t.Name, t.Namespace)
+}
+
+func (t *PatchTarget) matches(obj objectMeta) bool {
+	gv, err := schema.ParseGroupVersion(obj.APIVersion)
+	if err != nil {
+		return false
+	}
+	if t.Group != "" && t.Group != gv.Group || t.Kind != "" && t.Kind != obj.Kind {
+		return false
+	}
+	for _, m := range []struct{ pattern, value string }{
+		{t.Name, obj.Metadata.Name},
+		{t.Namespace, obj.Metadata.Namespace},
+	} {
+		if m.pattern == "" {
+			continue
+		}
+		if ok, err := path.Match(m.pattern, m.value); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// IsOverlay returns whether the path is an overlay directory.
+func IsOverlay(dir string) bool {
+	file, err := os.Stat(filepath.Join(dir, OverlayFileName))
+	return err == nil && !file.IsDir()
+}
+
+// LoadOverlay reads the overlay file of the directory.
+func LoadOverlay(dir string) (*Overlay, error) {
+	name := filepath.Join(dir, OverlayFileName)
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	o := &Overlay{}
+	if err := yaml.UnmarshalStrict(content, o); err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", name, err)
+	}
+	if o.Base == "" {
+		return nil, fmt.Errorf("parsing %v: missing base directory", name)
+	}
+	for _, p := range o.Patches {
+		if p.Path == "" {
+			return nil, fmt.Errorf("parsing %v: patch without a path", name)
+		}
+	}
+	return o, nil
+}
+
+// baseDir returns the base directory of the overlay in dir.
+func (o *Overlay) baseDir(dir string) string {
+	if filepath.IsAbs(o.Base) {
+		return o.Base
+	}
+	return filepath.Join(dir, o.Base)
+}
+
+// overlayFile is a deployment file listed for an overlay.
+type overlayFile struct {
+	path string
+	// rel is the path relative to the directory of the base or overlay the file is in.
+	// Overlay files replace the base files with the same relative path.
+	rel string
+}
+
+// overlayFilesList returns the deployment files of the base directory, with the files the overlay replaces
+// swapped in place, followed by the files the overlay adds.
+func overlayFilesList(dir string) ([]string, error) {
+	files, err := overlayFiles(dir, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	fileList := make([]string, 0, len(files))
+	for _, f := range files {
+		fileList = append(fileList, f.path)
+	}
+	return fileList, nil
+}
+
+// overlayFiles lists the files of the overlay in dir.
+// visited holds the overlays that are already being listed to detect cycles.
+func overlayFiles(dir string, visited map[string]bool) ([]overlayFile, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if visited[abs] {
+		return nil, fmt.Errorf("overlay %v is its own base", dir)
+	}
+	visited[abs] = true
+
+	o, err := LoadOverlay(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := o.baseDir(dir)
+	if file, err := os.Stat(base); err != nil || !file.IsDir() {
+		return nil, fmt.Errorf("base %v of overlay %v is not a directory", base, dir)
+	}
+	var files []overlayFile
+	if IsOverlay(base) {
+		files, err = overlayFiles(base, visited)
+	} else {
+		files, err = dirFiles(base)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing base of overlay %v: %w", dir, err)
+	}
+
+	skip := map[string]bool{filepath.Join(dir, OverlayFileName): true}
+	for _, p := range o.Patches {
+		skip[filepath.Join(dir, p.Path)] = true
+	}
+	own, err := dirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range own {
+		if skip[f.path] {
+			continue
+		}
+		if i := slices.IndexFunc(files, func(b overlayFile) bool { return b.rel == f.rel }); i >= 0 {
+			files[i] = f
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// dirFiles lists the deployment files in the directory and its subdirectories.
+func dirFiles(dir string) ([]overlayFile, error) {
+	var files []overlayFile
+	if err := filepath.Walk(dir, func(path string, _ os.FileInfo, _ error) error {
+		if filepath.Base(path) == VarsFileName {
+			return nil
+		}
+		if filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".yml" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, overlayFile{path: path, rel: rel})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+	return files, nil
+}
+
+// applyOverlay applies the patches of the overlay, and those of its bases, to the rendered deployment files.
+// Every patch has to match at least one object.
+func applyOverlay(dir string, resources []Resource, deploymentVars map[string]string) error {
+	files, err := overlayFiles(dir, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	return applyOverlayPatches(dir, files, resources, deploymentVars)
+}
+
+// applyOverlayPatches applies the patches of the overlay in dir and its bases to the resources, which are
+// rendered from the rendered files of the top overlay.
+// A patch of a base matching objects in a file the top overlay replaced is a conflict, as the patch
+// was written for the replaced file.
+func applyOverlayPatches(dir string, rendered []overlayFile, resources []Resource, deploymentVars map[string]string) error {
+	o, err := LoadOverlay(dir)
+	if err != nil {
+		return err
+	}
+	if base := o.baseDir(dir); IsOverlay(base) {
+		if err := applyOverlayPatches(base, rendered, resources, deploymentVars); err != nil {
+			return err
+		}
+	}
+	files, err := overlayFiles(dir, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	inOverlay := map[string]bool{}
+	replaced := map[string]string{}
+	for _, f := range files {
+		inOverlay[f.path] = true
+		replaced[f.rel] = f.path
+	}
+	// replacedBy maps the files of the top overlay to the file of this overlay they replaced.
+	replacedBy := map[string]string{}
+	for _, f := range rendered {
+		if path, ok := replaced[f.rel]; ok && path != f.path {
+			replacedBy[f.path] = path
+		}
+	}
+
+	specs, err := LoadVarSpecs(dir)
+	if err != nil {
+		return err
+	}
+	for _, p := range o.Patches {
+		name := filepath.Join(dir, p.Path)
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return &TemplateError{File: name, Err: err}
+		}
+		content, err = applyTemplateVars(content, specs.withDefaults(deploymentVars))
+		if err != nil {
+			return &TemplateError{File: name, Err: err}
+		}
+		patch, err := sigsYaml.YAMLToJSON(content)
+		if err != nil {
+			return &DecodeError{File: name, Err: err}
+		}
+
+		apply, target, err := patchFunc(patch, p.Target)
+		if err != nil {
+			return &DecodeError{File: name, Err: err}
+		}
+		matched := false
+		for i, r := range resources {
+			if original, ok := replacedBy[r.FileName]; ok {
+				if _, ok, _ := patchDocuments(r.Content, target, apply); ok {
+					return fmt.Errorf("patch %v targets %v, which is replaced by %v", name, original, r.FileName)
+				}
+				continue
+			}
+			if !inOverlay[r.FileName] {
+				continue
+			}
+			patched, ok, err := patchDocuments(r.Content, target, apply)
+			if err != nil {
+				return fmt.Errorf("applying patch %v to %v: %w", name, r.FileName, err)
+			}
+			if ok {
+				resources[i].Content = patched
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("patch %v matched no objects with %v", name, target)
+		}
+	}
+	return nil
+}
+
+// patchFunc returns the function applying the patch to a json object and the target of the patch.
+func patchFunc(patch []byte, target *PatchTarget) (func(doc []byte, gvk schema.GroupVersionKind) ([]byte, error), *PatchTarget, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(patch), []byte("[")) {
+		if target == nil {
+			return nil, nil, fmt.Errorf("JSON patches need a target")
+		}
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(doc []byte, _ schema.GroupVersionKind) ([]byte, error) {
+			return ops.Apply(doc)
+		}, target, nil
+	}
+
+	if target == nil {
+		obj := objectMeta{}
+		if err := json.Unmarshal(patch, &obj); err != nil {
+			return nil, nil, err
+		}
+		gv, err := schema.ParseGroupVersion(obj.APIVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		if obj.Kind == "" || obj.Metadata.Name == "" {
+			return nil, nil, fmt.Errorf("strategic merge patches without a target need a kind and a name")
+		}
+		target = &PatchTarget{Group: gv.Group, Kind: obj.Kind, Name: obj.Metadata.Name, Namespace: obj.Metadata.Namespace}
+	}
+	return func(doc []byte, gvk schema.GroupVersionKind) ([]byte, error) {
+		// Kinds unknown to the scheme, like custom resources, have no patch strategies and get a JSON merge patch.
+		dataStruct, err := scheme.Scheme.New(gvk)
+		if err != nil {
+			return jsonpatch.MergePatch(doc, patch)
+		}
+		return strategicpatch.StrategicMergePatch(doc, patch, dataStruct)
+	}, target, nil
+}
+
+// patchDocuments applies the patch to the documents of a deployment file matching the target.
+// It returns whether any document matched.
+func patchDocuments(content []byte, target *PatchTarget, apply func([]byte, schema.GroupVersionKind) ([]byte, error)) ([]byte, bool, error) {
+	docs := SplitDocuments(content)
+	matched := false
+	for i, text := range docs {
+		doc, err := sigsYaml.YAMLToJSON(text)
+		if err != nil {
+			return nil, false, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		obj := objectMeta{}
+		if err := json.Unmarshal(doc, &obj); err != nil || !target.matches(obj) {
+			continue
+		}
+		patched, err := apply(doc, schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+		if err != nil {
+			return nil, false, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		if docs[i], err = sigsYaml.JSONToYAML(patched); err != nil {
+			return nil, false, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		matched = true
+	}
+	if !matched {
+		return content, false, nil
+	}
+	return bytes.Join(docs, []byte("\n"+Separator+"\n")), true, nil
+}
@@ -15,32 +15,127 @@ package provider
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 )
 
 const (
-	EKSRetryCount    = 100
-	GlobalRetryCount = 50
-	Separator        = "---"
-	globalRetryTime  = 10 * time.Second
+	// EKSRetryTimeout and GlobalRetryTimeout are how long the retry helpers wait for an EKS
+	// and any other request to complete. A --timeout that is reached earlier still aborts the wait.
+	EKSRetryTimeout    = 20 * time.Minute
+	GlobalRetryTimeout = 10 * time.Minute
+	Separator          = "---"
 )
 
+// retryBackoff is the delay between the attempts of the retry helpers.
+// It is a variable so that tests don't have to wait for real clusters.
+var retryBackoff = backoff{
+	initial: 10 * time.Second,
+	max:     time.Minute,
+	factor:  1.5,
+	jitter:  0.2,
+}
+
+// SetRetryBackoff sets the initial and the maximum delay between the attempts of the retry helpers
+// and returns a function restoring the previous delays. It is meant for the tests of the provider
+// packages against fake cloud APIs.
+func SetRetryBackoff(initial, maxDelay time.Duration) (restore func()) {
+	prev := retryBackoff
+	retryBackoff.initial, retryBackoff.max = initial, maxDelay
+	return func() { retryBackoff = prev }
+}
+
+// backoff grows the delay exponentially from initial up to max.
+// Every delay is randomised by ±jitter so that parallel pollers don't hit the API at the same time.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	factor  float64
+	jitter  float64
+}
+
+// delay returns the time to wait before the given attempt, starting from 1.
+func (b backoff) delay(attempt int) time.Duration {
+	d := float64(b.initial)
+	for i := 1; i < attempt && d < float64(b.max); i++ {
+		d *= b.factor
+	}
+	d = min(d, float64(b.max))
+	d += d * b.jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// sleep waits for d or until the context is done, in which case it returns the reason for the cancellation.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
 // DeploymentResource holds list of variables and corresponding files.
 type DeploymentResource struct {
 	// DeploymentFiles files provided from the cli.
 	DeploymentFiles []string
 	// DeploymentVars provided from the cli.
 	FlagDeploymentVars map[string]string
+	// VarsFiles provided from the cli, later files override the earlier ones.
+	VarsFiles []string
 	// Default DeploymentVars.
 	DefaultDeploymentVars map[string]string
 }
 
+// VarLayer is a set of variables from a single source.
+type VarLayer struct {
+	// Name describes the source of the variables, e.g. the vars file name.
+	Name string
+	Vars map[string]string
+}
+
+// VarLayers returns the variable layers from the lowest to the highest precedence:
+// the default variables, the variables of the provider, the vars files in the order
+// they were passed and the variables passed with -v.
+func (d *DeploymentResource) VarLayers(providerName string, providerVars map[string]string) ([]VarLayer, error) {
+	layers := []VarLayer{{Name: "default", Vars: d.DefaultDeploymentVars}}
+	if len(providerVars) > 0 {
+		layers = append(layers, VarLayer{Name: providerName, Vars: providerVars})
+	}
+	for _, name := range d.VarsFiles {
+		vars, err := LoadVarsFile(name)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, VarLayer{Name: name, Vars: vars})
+	}
+	return append(layers, VarLayer{Name: "flag", Vars: d.FlagDeploymentVars}), nil
+}
+
+// MergeVarLayers merges the layers with MergeDeploymentVars and returns which layer each value came from.
+func MergeVarLayers(layers []VarLayer) (deploymentVars, sources map[string]string) {
+	ms := make([]map[string]string, 0, len(layers))
+	sources = map[string]string{}
+	for _, l := range layers {
+		ms = append(ms, l.Vars)
+		for k := range l.Vars {
+			sources[k] = l.Name
+		}
+	}
+	return MergeDeploymentVars(ms...), sources
+}
+
 // NewDeploymentResource returns DeploymentResource with default values.
 func NewDeploymentResource() *DeploymentResource {
 	return &DeploymentResource{
@@ -69,10 +164,13 @@ type Checker struct {
 
 // RetryUntilAllTrue polls all checkers until they all return true or an error occurs.
 // It prints a combined readiness status at each iteration.
-func RetryUntilAllTrue(retryCount int, checkers []Checker) error {
-	const retryInterval = 15 * time.Second
+// Cancelling the context, reaching its deadline or waiting longer than timeout aborts the wait.
+func RetryUntilAllTrue(ctx context.Context, timeout time.Duration, checkers []Checker) error {
+	ctx, cancel := withRetryTimeout(ctx, timeout)
+	defer cancel()
+
 	ready := make([]bool, len(checkers))
-	for i := 1; i <= retryCount; i++ {
+	for i := 1; ; i++ {
 		allReady := true
 		for j, c := range checkers {
 			if ready[j] {
@@ -100,90 +198,203 @@ func RetryUntilAllTrue(retryCount int, checkers []Checker) error {
 		if allReady {
 			return nil
 		}
-		time.Sleep(retryInterval)
+		if err := sleep(ctx, retryBackoff.delay(i)); err != nil {
+			return fmt.Errorf("waiting for %s aborted: %w", strings.Join(notReady(checkers, ready), ", "), err)
+		}
 	}
-	var notReady []string
+}
+
+// withRetryTimeout returns a context that is done after timeout, with a cause telling the timeout apart
+// from the cancellation of the parent context.
+func withRetryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("not done within %v: %w", timeout, context.DeadlineExceeded))
+}
+
+func notReady(checkers []Checker, ready []bool) []string {
+	var names []string
 	for j, c := range checkers {
 		if !ready[j] {
-			notReady = append(notReady, c.Name)
+			names = append(names, c.Name)
 		}
 	}
-	return fmt.Errorf("resources not ready after %d retries: %s", retryCount, strings.Join(notReady, ", "))
+	return names
 }
 
 // RetryUntilTrue returns when there is an error or the requested operation returns true.
-func RetryUntilTrue(name string, retryCount int, fn func() (bool, error)) error {
-	for i := 1; i <= retryCount; i++ {
-		time.Sleep(globalRetryTime)
-		if ready, err := fn(); err != nil {
+// The operation is checked right away and then again after every backoff delay.
+// Cancelling the context, reaching its deadline or waiting longer than timeout aborts the wait.
+func RetryUntilTrue(ctx context.Context, name string, timeout time.Duration, fn func() (bool, error)) error {
+	ctx, cancel := withRetryTimeout(ctx, timeout)
+	defer cancel()
+
+	for i := 1; ; i++ {
+		ready, err := fn()
+		if err != nil {
 			return err
-		} else if !ready {
-			log.Printf("Request for '%v' is in progress. Checking in %v", name, globalRetryTime)
-			continue
 		}
-		log.Printf("Request for '%v' is done!", name)
-		return nil
+		if ready {
+			log.Printf("Request for '%v' is done!", name)
+			return nil
+		}
+		delay := retryBackoff.delay(i)
+		log.Printf("Request for '%v' is in progress. Checking in %v", name, delay.Round(time.Second))
+		if err := sleep(ctx, delay); err != nil {
+			return fmt.Errorf("Request for '%v' aborted: %w", name, err)
+		}
 	}
-	return fmt.Errorf("Request for '%v' hasn't completed after retrying %d times", name, retryCount)
 }
 
 // applyTemplateVars applies golang templates to deployment files.
+// Unset variables render as empty strings, so that the template functions can handle them,
+// while the variables used as .NAME have to be set.
 func applyTemplateVars(content []byte, deploymentVars map[string]string) ([]byte, error) {
 	fileContentParsed := bytes.NewBufferString("")
-	t := template.New("resource").Option("missingkey=error")
-	t = t.Funcs(template.FuncMap{
-		// k8s objects can't have dots(.) se we add a custom function to allow normalising the variable values.
-		"normalise": func(t string) string {
-			return strings.ReplaceAll(t, ".", "-")
-		},
-		"split": func(rangeVars, separator string) []string {
-			return strings.Split(rangeVars, separator)
-		},
-	})
-	if err := template.Must(t.Parse(string(content))).Execute(fileContentParsed, deploymentVars); err != nil {
+	t, err := newTemplate(content)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse template err: %w", err)
+	}
+	// Report all unset variables at once, the ones passed to required fail with its message instead.
+	var unset []string
+	for _, k := range sortedKeys(collectVars(t).required) {
+		if _, ok := deploymentVars[k]; !ok {
+			unset = append(unset, k)
+		}
+	}
+	if len(unset) > 0 {
+		return nil, fmt.Errorf("Failed to execute parse file, unset variables: %s", strings.Join(unset, ", "))
+	}
+	if err := t.Execute(fileContentParsed, deploymentVars); err != nil {
 		return nil, fmt.Errorf("Failed to execute parse file err: %w", err)
 	}
 	return fileContentParsed.Bytes(), nil
 }
 
+// newTemplate parses the content of a deployment file.
+func newTemplate(content []byte) (*template.Template, error) {
+	return template.New("resource").Option("missingkey=zero").Funcs(templateFuncs).Parse(string(content))
+}
+
 // DeploymentsParse parses the deployment files and returns the result as bytes grouped by the filename.
 // Any variables passed to the cli will be replaced in the resources files following the golang text template format.
+// The patches of overlay directories are applied to the parsed files of their base.
 func DeploymentsParse(deploymentFiles []string, deploymentVars map[string]string) ([]Resource, error) {
-	var fileList []string
-	for _, name := range deploymentFiles {
-		if file, err := os.Stat(name); err == nil && file.IsDir() {
-			if err := filepath.Walk(name, func(path string, _ os.FileInfo, _ error) error {
-				if filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml" {
-					fileList = append(fileList, path)
-				}
-				return nil
-			}); err != nil {
-				return nil, fmt.Errorf("error reading directory: %w", err)
-			}
-		} else {
-			fileList = append(fileList, name)
+	fileList, err := DeploymentFilesList(deploymentFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the required variables of all directories first to report all missing ones at once.
+	specs := map[string]VarSpecs{}
+	var missing []string
+	for _, name := range fileList {
+		dir := filepath.Dir(name)
+		if _, ok := specs[dir]; ok {
+			continue
+		}
+		if specs[dir], err = LoadVarSpecs(dir); err != nil {
+			return nil, err
+		}
+		for _, k := range specs[dir].missing(deploymentVars) {
+			missing = append(missing, fmt.Sprintf("%v (%v)", k, filepath.Join(dir, VarsFileName)))
 		}
 	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required variables: %s", strings.Join(missing, ", "))
+	}
 
 	deploymentObjects := make([]Resource, 0)
 	for _, name := range fileList {
 		absFileName := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
 		content, err := os.ReadFile(name)
 		if err != nil {
-			log.Fatalf("Error reading file %v:%v", name, err)
+			return nil, &TemplateError{File: name, Err: err}
 		}
 		// Don't parse file with the suffix "noparse".
 		if !strings.HasSuffix(absFileName, "noparse") {
-			content, err = applyTemplateVars(content, deploymentVars)
+			content, err = applyTemplateVars(content, specs[filepath.Dir(name)].withDefaults(deploymentVars))
 			if err != nil {
-				return nil, fmt.Errorf("couldn't apply template to file %s: %w", name, err)
+				return nil, &TemplateError{File: name, Err: err}
 			}
 		}
 		deploymentObjects = append(deploymentObjects, Resource{FileName: name, Content: content})
 	}
+
+	for _, name := range deploymentFiles {
+		if IsOverlay(name) {
+			if err := applyOverlay(name, deploymentObjects, deploymentVars); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return deploymentObjects, nil
 }
 
+// SplitDocuments splits the content of a deployment file into its non-empty yaml documents.
+func SplitDocuments(content []byte) [][]byte {
+	var docs [][]byte
+	for _, text := range strings.Split(string(content), Separator) {
+		text = strings.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+		docs = append(docs, []byte(text))
+	}
+	return docs
+}
+
+// DeploymentFilesList returns the deployment files with the directories replaced by the yaml files in them.
+// The vars files declaring the variables of a directory are not deployment files and are skipped.
+// Overlay directories are replaced by the files of their base directory and the files they add.
+func DeploymentFilesList(deploymentFiles []string) ([]string, error) {
+	var fileList []string
+	for _, name := range deploymentFiles {
+		if IsOverlay(name) {
+			files, err := overlayFilesList(name)
+			if err != nil {
+				return nil, err
+			}
+			fileList = append(fileList, files...)
+			continue
+		}
+		if file, err := os.Stat(name); err == nil && file.IsDir() {
+			files, err := dirFiles(name)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				fileList = append(fileList, f.path)
+			}
+		} else {
+			fileList = append(fileList, name)
+		}
+	}
+	return fileList, nil
+}
+
+// ExtractWave returns the wave number from a filename by splitting on "_"
+// and parsing the first part as an integer.
+// For example, "path/to/4_fake-webserver.yaml" returns 4.
+// If no valid integer prefix is found, it returns 0.
+func ExtractWave(fileName string) int {
+	base := filepath.Base(fileName)
+	parts := strings.SplitN(base, "_", 2)
+	if len(parts) < 2 {
+		return 0
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// SortByWave sorts the resources by their wave number, keeping the original order within a wave.
+func SortByWave(resources []Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		return ExtractWave(resources[i].FileName) < ExtractWave(resources[j].FileName)
+	})
+}
+
 // MergeDeploymentVars merges multiple maps based on the order.
 func MergeDeploymentVars(ms ...map[string]string) map[string]string {
 	res := map[string]string{}
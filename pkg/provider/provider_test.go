@@ -14,8 +14,14 @@
 package provider
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMergeDeploymentVars(t *testing.T) {
@@ -57,3 +63,550 @@ func TestMergeDeploymentVars(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractWave(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     int
+	}{
+		{"4_fake-webserver.yaml", 4},
+		{"path/to/5_prometheus-test-pr_deployment.yaml", 5},
+		{"10_loadgen.yaml", 10},
+		{"1_namespace.yaml", 1},
+		{"no_number.yaml", 0},
+		{"", 0},
+		{"nodash", 0},
+		{"/etc/scaler/webserver.yaml", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			got := ExtractWave(tt.fileName)
+			if got != tt.want {
+				t.Errorf("ExtractWave(%q) = %d, want %d", tt.fileName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByWave(t *testing.T) {
+	resources := []Resource{
+		{FileName: "grafana_deployment.yaml"},
+		{FileName: "2_ingress-nginx-controller.yaml"},
+		{FileName: "1_namespace.yaml"},
+		{FileName: "2_serviceaccount.yaml"},
+		{FileName: "10_loadgen.yaml"},
+		{FileName: "node-exporter.yaml"},
+	}
+	SortByWave(resources)
+
+	var got []string
+	for _, r := range resources {
+		got = append(got, r.FileName)
+	}
+	want := []string{
+		"grafana_deployment.yaml",
+		"node-exporter.yaml",
+		"1_namespace.yaml",
+		"2_ingress-nginx-controller.yaml",
+		"2_serviceaccount.yaml",
+		"10_loadgen.yaml",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("\nexpect %v\ngot %v", want, got)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := backoff{initial: time.Second, max: 4 * time.Second, factor: 2, jitter: 0.2}
+	for attempt, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		10: 4 * time.Second,
+	} {
+		got := b.delay(attempt)
+		if got < want*8/10 || got > want*12/10 {
+			t.Errorf("attempt %d: expected delay within 20%% of %v, got %v", attempt, want, got)
+		}
+	}
+}
+
+func TestRetryUntilTrue(t *testing.T) {
+	defer func(b backoff) { retryBackoff = b }(retryBackoff)
+	retryBackoff = backoff{initial: time.Millisecond, max: time.Millisecond, factor: 2}
+
+	calls := 0
+	err := RetryUntilTrue(context.Background(), "test", time.Minute, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+
+	err = RetryUntilTrue(context.Background(), "test", 10*time.Millisecond, func() (bool, error) { return false, nil })
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "not done within 10ms") {
+		t.Errorf("expected an error after running out of time, got %v", err)
+	}
+}
+
+func TestRetryUntilTrueChecksFirst(t *testing.T) {
+	defer func(b backoff) { retryBackoff = b }(retryBackoff)
+	retryBackoff = backoff{initial: time.Hour, max: time.Hour, factor: 2}
+
+	// A completed operation returns without waiting for the first delay.
+	if err := RetryUntilTrue(context.Background(), "test", time.Minute, func() (bool, error) { return true, nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryUntilTrueCancel(t *testing.T) {
+	defer func(b backoff) { retryBackoff = b }(retryBackoff)
+	retryBackoff = backoff{initial: time.Hour, max: time.Hour, factor: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := RetryUntilTrue(ctx, "test", time.Minute, func() (bool, error) { return false, nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline exceeded error, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	checkers := []Checker{{Name: "Deployment/test", Check: func() (bool, error) { return false, nil }}}
+	err = RetryUntilAllTrue(ctx, time.Minute, checkers)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation error, got %v", err)
+	}
+}
+
+func TestTemplateVars(t *testing.T) {
+	testCases := []struct {
+		content  string
+		required []string
+		optional []string
+	}{
+		{
+			content:  `name: prometheus-{{ .PR_NUMBER }}`,
+			required: []string{"PR_NUMBER"},
+		},
+		{
+			content:  `{{ if index . "RELEASE" }}image: {{ .RELEASE }}{{ end }}{{ index $ "DOMAIN_NAME" }}`,
+			required: []string{"RELEASE"},
+			optional: []string{"DOMAIN_NAME"},
+		},
+		{
+			content:  `{{ range .NODES }}{{ .Name }} {{ $.ZONE }}{{ end }}`,
+			required: []string{"NODES", "ZONE"},
+		},
+		{
+			content:  `{{ range .NODES }}{{ default "main" $.RELEASE }}{{ index $ "DOMAIN_NAME" }}{{ end }}`,
+			required: []string{"NODES"},
+			optional: []string{"DOMAIN_NAME", "RELEASE"},
+		},
+		{
+			// Templates invoked with the root data see the variables, other data doesn't.
+			content:  `{{ define "image" }}{{ .REGISTRY }}/{{ default "prometheus" .IMAGE }}{{ end }}{{ with .RELEASE }}{{ template "image" $ }}:{{ template "tag" . }}{{ end }}{{ define "tag" }}{{ .Tag }}{{ end }}`,
+			required: []string{"REGISTRY", "RELEASE"},
+			optional: []string{"IMAGE"},
+		},
+		{
+			content:  `{{ block "name" . }}{{ .PR_NUMBER | required "PR_NUMBER is needed" }}{{ end }}`,
+			required: []string{"PR_NUMBER"},
+		},
+		{
+			content: `no variables`,
+		},
+	}
+	for _, tc := range testCases {
+		required, optional, err := TemplateVars([]byte(tc.content))
+		if err != nil {
+			t.Fatalf("%q: %v", tc.content, err)
+		}
+		if len(required)+len(tc.required) > 0 && !reflect.DeepEqual(required, tc.required) {
+			t.Errorf("%q: expected required %v, got %v", tc.content, tc.required, required)
+		}
+		if len(optional)+len(tc.optional) > 0 && !reflect.DeepEqual(optional, tc.optional) {
+			t.Errorf("%q: expected optional %v, got %v", tc.content, tc.optional, optional)
+		}
+	}
+}
+
+func TestVarSpecs(t *testing.T) {
+	dir := t.TempDir()
+	specs, err := LoadVarSpecs(dir)
+	if err != nil || specs != nil {
+		t.Fatalf("expected no specs without a vars file, got %v, %v", specs, err)
+	}
+
+	vars := `
+PR_NUMBER:
+  required: true
+RELEASE:
+  default: main
+DOMAIN_NAME:
+  description: domain
+`
+	if err := os.WriteFile(filepath.Join(dir, VarsFileName), []byte(vars), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	specs, err = LoadVarSpecs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := specs.missing(map[string]string{"RELEASE": "v2.45.0"}); !reflect.DeepEqual(missing, []string{"PR_NUMBER"}) {
+		t.Errorf("expected PR_NUMBER to be missing, got %v", missing)
+	}
+	if missing := specs.missing(map[string]string{"PR_NUMBER": "1"}); len(missing) != 0 {
+		t.Errorf("expected no missing variables, got %v", missing)
+	}
+
+	expected := map[string]string{"PR_NUMBER": "1", "RELEASE": "main"}
+	if got := specs.withDefaults(map[string]string{"PR_NUMBER": "1"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	expected = map[string]string{"PR_NUMBER": "1", "RELEASE": "v2.45.0"}
+	if got := specs.withDefaults(map[string]string{"PR_NUMBER": "1", "RELEASE": "v2.45.0"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, VarsFileName), []byte("PR_NUMBER:\n  requird: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVarSpecs(dir); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Setenv("INFRA_TEST_ENV", "from-env")
+	vars := map[string]string{
+		"PR_NUMBER": "123",
+		"REPLICAS":  "3",
+		"TOKEN":     "secret",
+		"RELEASE":   "v2.45.0",
+		"EMPTY":     "",
+	}
+	testCases := []struct {
+		template string
+		expected string
+	}{
+		{`{{ default "main" .UNSET }}`, "main"},
+		{`{{ .UNSET | default "main" }}`, "main"},
+		{`{{ default "main" .EMPTY }}`, "main"},
+		{`{{ .RELEASE | default "main" }}`, "v2.45.0"},
+		{`{{ coalesce .UNSET .EMPTY .RELEASE }}`, "v2.45.0"},
+		{`{{ required "PR_NUMBER is needed" .PR_NUMBER }}`, "123"},
+		{`{{ empty .UNSET }} {{ empty .PR_NUMBER }}`, "true false"},
+		{`{{ ternary "yes" "no" (empty .EMPTY) }}`, "yes"},
+		{`{{ add .REPLICAS 1 }} {{ sub .REPLICAS 1 }} {{ mul .REPLICAS 2 }} {{ div .REPLICAS 2 }} {{ mod .REPLICAS 2 }}`, "4 2 6 1 1"},
+		{`{{ max .REPLICAS 5 }} {{ min .REPLICAS 5 }} {{ int .REPLICAS | add 1 }}`, "5 3 4"},
+		{`{{ b64enc .TOKEN }} {{ b64enc .TOKEN | b64dec }}`, "c2VjcmV0 secret"},
+		{`{{ sha256sum .TOKEN }}`, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{`{{ quote .RELEASE }} {{ squote .RELEASE }} {{ upper .TOKEN }}`, `"v2.45.0" 'v2.45.0' SECRET`},
+		{`{{ squote "it's" }}`, `'it''s'`},
+		{`{{ .RELEASE | trimPrefix "v" | normalise }}`, "2-45-0"},
+		{`{{ split "a,b" "," | join "-" }}`, "a-b"},
+		{`{{ split "a,b" "," | toYaml | nindent 2 }}`, "\n  - a\n  - b"},
+		{`{{ split "a,b" "," | toJson }}`, `["a","b"]`},
+		{`{{ env "INFRA_TEST_ENV" }}`, "from-env"},
+		{`{{ range split "a,b" "," }}{{ . | default "x" }}{{ end }}`, "ab"},
+		{`{{ range split "a,b" "," }}{{ default "x" $.UNSET }}{{ $.PR_NUMBER }}{{ end }}`, "x123x123"},
+		{`{{ with .RELEASE }}{{ . }}-{{ $.PR_NUMBER }}{{ end }}`, "v2.45.0-123"},
+		{`{{ define "name" }}{{ default "prometheus" .UNSET }}-{{ .PR_NUMBER }}{{ end }}{{ template "name" . }}`, "prometheus-123"},
+	}
+	for _, tc := range testCases {
+		got, err := applyTemplateVars([]byte(tc.template), vars)
+		if err != nil {
+			t.Errorf("%v: %v", tc.template, err)
+			continue
+		}
+		if string(got) != tc.expected {
+			t.Errorf("%v: expected %q, got %q", tc.template, tc.expected, got)
+		}
+	}
+
+	for _, tmpl := range []string{
+		`{{ required "PR_NUMBER is needed" .UNSET }}`,
+		`{{ .EMPTY | required "EMPTY is needed" }}`,
+		`{{ add .TOKEN 1 }}`,
+		`{{ div .REPLICAS 0 }}`,
+		`{{ .UNSET }}`,
+		`{{ range split "a,b" "," }}{{ $.UNSET }}{{ end }}`,
+		`{{ define "name" }}{{ .UNSET }}{{ end }}{{ template "name" . }}`,
+	} {
+		if _, err := applyTemplateVars([]byte(tmpl), vars); err == nil {
+			t.Errorf("%v: expected an error", tmpl)
+		}
+	}
+	if _, err := applyTemplateVars([]byte(`{{ .UNSET }}{{ .OTHER }}`), vars); err == nil || !strings.Contains(err.Error(), "unset variables: OTHER, UNSET") {
+		t.Errorf("expected all unset variables to be reported, got %v", err)
+	}
+	if _, err := applyTemplateVars([]byte(`{{ required "PR_NUMBER is needed" .UNSET }}`), vars); err == nil || !strings.Contains(err.Error(), "PR_NUMBER is needed") {
+		t.Errorf("expected the message of required, got %v", err)
+	}
+
+	required, optional, err := TemplateVars([]byte(`{{ required "msg" .A }}{{ .B | required "msg" }}{{ default "x" .C }}{{ .D | default "x" }}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(required, []string{"A", "B"}) || !reflect.DeepEqual(optional, []string{"C", "D"}) {
+		t.Errorf("expected required [A B] and optional [C D], got %v and %v", required, optional)
+	}
+}
+
+func TestVarLayers(t *testing.T) {
+	t.Setenv("INFRA_TEST_PR", "123")
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	if err := os.WriteFile(first, []byte("PR_NUMBER: ${INFRA_TEST_PR}\nRELEASE: v2.44.0\nREPLICAS: 3\nPRICE: $$5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("RELEASE: v2.45.0\nZONE: europe-west1-b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dr := &DeploymentResource{
+		DefaultDeploymentVars: map[string]string{"SEPARATOR": ",", "ZONE": "default-zone", "NGINX_SERVICE_TYPE": "LoadBalancer"},
+		VarsFiles:             []string{first, second},
+		FlagDeploymentVars:    map[string]string{"ZONE": "us-east1-b"},
+	}
+	layers, err := dr.VarLayers("kind", map[string]string{"NGINX_SERVICE_TYPE": "NodePort"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, sources := MergeVarLayers(layers)
+
+	expectedVars := map[string]string{
+		"SEPARATOR":          ",",
+		"NGINX_SERVICE_TYPE": "NodePort",
+		"PR_NUMBER":          "123",
+		"RELEASE":            "v2.45.0",
+		"REPLICAS":           "3",
+		"PRICE":              "$5",
+		"ZONE":               "us-east1-b",
+	}
+	if !reflect.DeepEqual(vars, expectedVars) {
+		t.Errorf("expected vars %v, got %v", expectedVars, vars)
+	}
+	expectedSources := map[string]string{
+		"SEPARATOR":          "default",
+		"NGINX_SERVICE_TYPE": "kind",
+		"PR_NUMBER":          first,
+		"RELEASE":            second,
+		"REPLICAS":           first,
+		"PRICE":              first,
+		"ZONE":               "flag",
+	}
+	if !reflect.DeepEqual(sources, expectedSources) {
+		t.Errorf("expected sources %v, got %v", expectedSources, sources)
+	}
+
+	if err := os.WriteFile(second, []byte("RELEASE: ${INFRA_TEST_UNSET}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dr.VarLayers("kind", nil); err == nil {
+		t.Error("expected an error for an unset environment variable")
+	}
+}
+
+func TestDeploymentsParseOverlay(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base/1_namespace.yaml": `apiVersion: v1
+kind: Namespace
+metadata:
+  name: prombench-{{ .PR_NUMBER }}
+`,
+		"base/2_prometheus.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: prometheus-config
+  namespace: prombench-{{ .PR_NUMBER }}
+data:
+  prometheus.yml: ""
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prometheus-test-pr-{{ .PR_NUMBER }}
+  namespace: prombench-{{ .PR_NUMBER }}
+spec:
+  template:
+    spec:
+      containers:
+      - name: prometheus
+        image: prometheus
+        args:
+        - --config.file=/etc/prometheus/prometheus.yml
+      - name: sidecar
+        image: sidecar
+`,
+		"overlay/overlay.yaml": `base: ../base
+patches:
+- path: native-histograms.yaml
+- path: storage.yaml
+  target:
+    kind: Deployment
+    name: prometheus-test-*
+`,
+		"overlay/native-histograms.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prometheus-test-pr-{{ .PR_NUMBER }}
+  namespace: prombench-{{ .PR_NUMBER }}
+spec:
+  template:
+    spec:
+      containers:
+      - name: prometheus
+        image: prometheus:native-histograms
+`,
+		"overlay/storage.yaml": `- op: add
+  path: /spec/template/spec/containers/0/args/-
+  value: --storage.tsdb.retention.time=1d
+`,
+		"overlay/3_extra.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: extra
+`,
+		"overlay/1_namespace.yaml": `apiVersion: v1
+kind: Namespace
+metadata:
+  name: prombench-{{ .PR_NUMBER }}
+  labels:
+    variant: overlay
+`,
+		// Files only replace the base file with the same path, not the same name.
+		"overlay/extra/2_prometheus.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: extra-prometheus
+`,
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overlay := filepath.Join(dir, "overlay")
+	fileList, err := DeploymentFilesList([]string{overlay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{
+		filepath.Join(dir, "overlay/1_namespace.yaml"),
+		filepath.Join(dir, "base/2_prometheus.yaml"),
+		filepath.Join(dir, "overlay/3_extra.yaml"),
+		filepath.Join(dir, "overlay/extra/2_prometheus.yaml"),
+	}
+	if !reflect.DeepEqual(fileList, expectedFiles) {
+		t.Fatalf("expected files %v, got %v", expectedFiles, fileList)
+	}
+
+	resources, err := DeploymentsParse([]string{overlay}, map[string]string{"PR_NUMBER": "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 4 {
+		t.Fatalf("expected 4 files, got %d", len(resources))
+	}
+	docs := SplitDocuments(resources[1].Content)
+	if len(docs) != 2 || !strings.Contains(string(docs[0]), "name: prometheus-config") {
+		t.Fatalf("expected the config map to be kept, got:\n%s", resources[1].Content)
+	}
+	expected := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prometheus-test-pr-123
+  namespace: prombench-123
+spec:
+  template:
+    spec:
+      containers:
+      - args:
+        - --config.file=/etc/prometheus/prometheus.yml
+        - --storage.tsdb.retention.time=1d
+        image: prometheus:native-histograms
+        name: prometheus
+      - image: sidecar
+        name: sidecar`
+	if string(docs[1]) != expected {
+		t.Errorf("expected patched deployment:\n%s\ngot:\n%s", expected, docs[1])
+	}
+
+	// A patch of a base overlay can't apply to a file replaced by the overlay on top of it.
+	top := filepath.Join(dir, "top")
+	if err := os.MkdirAll(top, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(top, "overlay.yaml"), []byte("base: ../overlay\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(top, "2_prometheus.yaml"), []byte(files["base/2_prometheus.yaml"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = DeploymentsParse([]string{top}, map[string]string{"PR_NUMBER": "123"})
+	if err == nil || !strings.Contains(err.Error(), "replaced by "+filepath.Join(top, "2_prometheus.yaml")) {
+		t.Errorf("expected a conflict between the base patch and the replaced file, got %v", err)
+	}
+
+	// A patch that doesn't match any object is an error.
+	if err := os.WriteFile(filepath.Join(overlay, "overlay.yaml"), []byte("base: ../base\npatches:\n- path: storage.yaml\n  target:\n    kind: StatefulSet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeploymentsParse([]string{overlay}, map[string]string{"PR_NUMBER": "123"}); err == nil {
+		t.Error("expected an error for a patch without matching objects")
+	}
+}
+
+func TestDeploymentsParseErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "1_bad.yaml")
+	if err := os.WriteFile(bad, []byte("name: {{ .PR_NUMBER }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, files := range [][]string{{bad}, {filepath.Join(dir, "missing.yaml")}} {
+		_, err := DeploymentsParse(files, map[string]string{"PR_NUMBER": "1"})
+		var templateErr *TemplateError
+		if !errors.As(err, &templateErr) {
+			t.Fatalf("%v: expected a TemplateError, got %v", files, err)
+		}
+		if templateErr.File != files[0] {
+			t.Errorf("expected the error for %v, got %v", files[0], templateErr.File)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer func(r []Registration) { registry = r }(registry)
+	registry = nil
+
+	Register(Registration{Name: "b"})
+	Register(Registration{Name: "a"})
+	var names []string
+	for _, r := range Registered() {
+		names = append(names, r.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("expected the providers sorted by name, got %v", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic when registering a provider twice")
+		}
+	}()
+	Register(Registration{Name: "a"})
+}
@@ -0,0 +1,111 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Provider gives access to the k8s API of a cluster on a platform.
+// The infra CLI generates the info and resource commands of every registered provider,
+// parses the deployment files and applies or deletes the k8s objects through the cluster of KubeConfig.
+type Provider interface {
+	// Flags registers the provider specific flags, like the credentials, on the provider command.
+	Flags(cmd *kingpin.CmdClause)
+	// Init is called with the final deployment variables before any cluster, nodes or resource command,
+	// e.g. to create the API client of the provider.
+	Init(deploymentVars map[string]string) error
+
+	// KubeConfig returns the config used to access the k8s API of the cluster named by the deployment variables.
+	// A nil config selects the in-cluster config of the pod infra runs in.
+	KubeConfig() (*clientcmdapi.Config, error)
+}
+
+// ClusterProvider is implemented by providers that create and delete clusters and their node pools.
+// The cluster and nodes commands are only generated for such providers.
+type ClusterProvider interface {
+	Provider
+
+	// ClusterCreate creates the clusters of the parsed deployment files and waits until they are running.
+	ClusterCreate(deployments []Resource) error
+	// ClusterDelete deletes the clusters of the parsed deployment files and waits until they are gone.
+	ClusterDelete(deployments []Resource) error
+	// NodePoolCreate adds the node pools of the parsed deployment files to their existing cluster.
+	NodePoolCreate(deployments []Resource) error
+	// NodePoolDelete deletes the node pools of the parsed deployment files.
+	NodePoolDelete(deployments []Resource) error
+	// NodePoolsRunning returns an error if at least one node pool of the deployment files isn't running.
+	NodePoolsRunning(deployments []Resource) error
+	// NodePoolsDeleted returns an error if at least one node pool of the deployment files isn't deleted.
+	NodePoolsDeleted(deployments []Resource) error
+}
+
+// Commander is implemented by providers with commands of their own next to the generated ones,
+// like the kind images commands.
+type Commander interface {
+	// Commands adds the provider commands to the provider command.
+	// The setup action checks the required variables, calls Init and parses the deployment files,
+	// as for the cluster commands, and run returns an action calling fn with the parsed files.
+	Commands(cmd *kingpin.CmdClause, setup kingpin.Action, run func(fn func(deployments []Resource) error) kingpin.Action)
+}
+
+// Registration describes a provider to the infra CLI.
+type Registration struct {
+	// Name is the name of the provider command, e.g. gke.
+	Name string
+	// Help is the help text of the provider command.
+	Help string
+	// DefaultVars are the provider defaults of the deployment variables, see DeploymentResource.VarLayers.
+	DefaultVars map[string]string
+	// RequiredVars are the deployment variables all cluster, nodes and resource commands need.
+	RequiredVars []string
+	// New creates the provider, which can also implement ClusterProvider and Commander.
+	// All API requests and waits are aborted when ctx is cancelled.
+	New func(ctx context.Context) Provider
+}
+
+var (
+	registryMtx sync.Mutex
+	registry    []Registration
+)
+
+// Register adds a provider to the infra CLI, usually from the init function of the provider package.
+// It panics when a provider with the same name is already registered.
+func Register(r Registration) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+
+	for _, reg := range registry {
+		if reg.Name == r.Name {
+			panic(fmt.Sprintf("provider %v is already registered", r.Name))
+		}
+	}
+	registry = append(registry, r)
+}
+
+// Registered returns the registered providers sorted by name.
+func Registered() []Registration {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+
+	regs := append([]Registration(nil), registry...)
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Name < regs[j].Name })
+	return regs
+}
@@ -0,0 +1,369 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v2"
+)
+
+// VarsFileName is the name of the file declaring the template variables of the deployment files in its directory.
+const VarsFileName = "vars.yaml"
+
+// VarSpec declares a template variable in a vars file.
+type VarSpec struct {
+	Description string `yaml:"description"`
+	// Required variables have to be set, rendering fails otherwise.
+	Required bool `yaml:"required"`
+	// Default is used when an optional variable isn't set.
+	Default *string `yaml:"default"`
+}
+
+// VarSpecs maps the variable names to their declaration.
+type VarSpecs map[string]VarSpec
+
+// LoadVarSpecs reads the vars file in the directory and returns nil when there is none.
+func LoadVarSpecs(dir string) (VarSpecs, error) {
+	name := filepath.Join(dir, VarsFileName)
+	content, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	specs := VarSpecs{}
+	if err := yaml.UnmarshalStrict(content, &specs); err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", name, err)
+	}
+	return specs, nil
+}
+
+// LoadVarsFile reads a yaml file mapping variable names to their values.
+// Environment variables in the values are expanded, where ${NAME} and $NAME refer to
+// the environment variable NAME and $$ is a literal $. Unset environment variables are an error.
+func LoadVarsFile(name string) (map[string]string, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if err := yaml.UnmarshalStrict(content, &vars); err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", name, err)
+	}
+	for k, v := range vars {
+		var unset []string
+		vars[k] = os.Expand(v, func(env string) string {
+			if env == "$" {
+				return "$"
+			}
+			value, ok := os.LookupEnv(env)
+			if !ok {
+				unset = append(unset, env)
+			}
+			return value
+		})
+		if len(unset) > 0 {
+			return nil, fmt.Errorf("parsing %v: variable %v uses unset environment variables: %v", name, k, strings.Join(unset, ", "))
+		}
+	}
+	return vars, nil
+}
+
+// PrintDeploymentVars writes the variables sorted by name along with the layer each value came from.
+func PrintDeploymentVars(w io.Writer, deploymentVars, sources map[string]string) {
+	keys := make([]string, 0, len(deploymentVars))
+	for k := range deploymentVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%-30s %-30s (%v)\n", k, deploymentVars[k], sources[k])
+	}
+}
+
+// withDefaults returns the variables with the defaults of the unset optional variables added.
+func (s VarSpecs) withDefaults(deploymentVars map[string]string) map[string]string {
+	if len(s) == 0 {
+		return deploymentVars
+	}
+	vars := make(map[string]string, len(deploymentVars)+len(s))
+	for k, v := range s {
+		if !v.Required && v.Default != nil {
+			vars[k] = *v.Default
+		}
+	}
+	for k, v := range deploymentVars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// missing returns the required variables that aren't set, sorted by name.
+func (s VarSpecs) missing(deploymentVars map[string]string) []string {
+	var missing []string
+	for k, v := range s {
+		if _, ok := deploymentVars[k]; v.Required && !ok {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// TemplateVars returns the variables referenced by the template, sorted by name.
+// Variables used as .NAME or passed to required are required, while variables looked up
+// with index . "NAME" or passed to default, empty or coalesce are optional.
+func TemplateVars(content []byte) (required, optional []string, err error) {
+	t, err := newTemplate(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	vars := collectVars(t)
+	for k := range vars.checked {
+		vars.required[k] = true
+	}
+	for k := range vars.required {
+		// A variable that is required somewhere in the file is required for the whole file.
+		delete(vars.optional, k)
+	}
+	return sortedKeys(vars.required), sortedKeys(vars.optional), nil
+}
+
+// templateVars are the variables a template references from the root data.
+type templateVars struct {
+	// required are used as .NAME, which renders an empty string when the variable isn't set.
+	required map[string]bool
+	// checked are passed to required, which fails with its own message when the variable isn't set.
+	checked map[string]bool
+	// optional are passed to the lenientFuncs or looked up with index.
+	optional map[string]bool
+
+	t *template.Template
+	// walked records the invoked templates already walked, along with their scope.
+	walked map[string]bool
+}
+
+// varScope tells whether the dot and $ refer to the root data.
+type varScope struct {
+	dot, dollar bool
+}
+
+// collectVars walks the template, including the templates it invokes, and records the variables it references.
+func collectVars(t *template.Template) *templateVars {
+	vars := &templateVars{
+		required: map[string]bool{},
+		checked:  map[string]bool{},
+		optional: map[string]bool{},
+		t:        t,
+		walked:   map[string]bool{},
+	}
+	vars.walk(t.Tree.Root, varScope{dot: true, dollar: true}, vars.required)
+	return vars
+}
+
+// walk records the variables of the node in use, unless a function the node is passed to changes that.
+func (v *templateVars) walk(node parse.Node, scope varScope, use map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			v.walk(c, scope, use)
+		}
+	case *parse.ActionNode:
+		v.walk(n.Pipe, scope, use)
+	case *parse.TemplateNode:
+		v.walk(n.Pipe, scope, use)
+		t := v.t.Lookup(n.Name)
+		if t == nil || t.Tree == nil {
+			return
+		}
+		// The invoked template only sees the root data when it is passed as its dot.
+		inner := varScope{}
+		if n.Pipe != nil && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 && isRootData(n.Pipe.Cmds[0].Args[0], scope) {
+			inner = varScope{dot: true, dollar: true}
+		}
+		if key := fmt.Sprintf("%v %v", n.Name, inner); !v.walked[key] {
+			v.walked[key] = true
+			v.walk(t.Tree.Root, inner, v.required)
+		}
+	case *parse.IfNode:
+		v.walk(n.Pipe, scope, use)
+		v.walk(n.List, scope, use)
+		v.walk(n.ElseList, scope, use)
+	case *parse.RangeNode:
+		// The dot is set to the elements inside range and with blocks.
+		v.walk(n.Pipe, scope, use)
+		v.walk(n.List, varScope{dollar: scope.dollar}, use)
+		v.walk(n.ElseList, scope, use)
+	case *parse.WithNode:
+		v.walk(n.Pipe, scope, use)
+		v.walk(n.List, varScope{dollar: scope.dollar}, use)
+		v.walk(n.ElseList, scope, use)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for i, cmd := range n.Cmds {
+			// A value piped into a function is used like its arguments.
+			if i == 0 && len(n.Cmds) > 1 {
+				v.walk(cmd, scope, v.funcUse(n.Cmds[1], use))
+				continue
+			}
+			v.walk(cmd, scope, use)
+		}
+	case *parse.CommandNode:
+		if name, ok := indexLookup(n, scope); ok {
+			v.optional[name] = true
+			return
+		}
+		use = v.funcUse(n, use)
+		for _, arg := range n.Args {
+			v.walk(arg, scope, use)
+		}
+	case *parse.FieldNode:
+		if scope.dot {
+			use[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		// $.NAME refers to the root data unless inside a template invoked with other data.
+		if scope.dollar && n.Ident[0] == "$" && len(n.Ident) > 1 {
+			use[n.Ident[1]] = true
+		}
+	case *parse.ChainNode:
+		v.walk(n.Node, scope, use)
+	}
+}
+
+// funcUse returns how the arguments of the command are used.
+func (v *templateVars) funcUse(n *parse.CommandNode, use map[string]bool) map[string]bool {
+	fn, ok := n.Args[0].(*parse.IdentifierNode)
+	switch {
+	case !ok:
+		return use
+	case fn.Ident == "required":
+		return v.checked
+	case lenientFuncs[fn.Ident]:
+		return v.optional
+	default:
+		return use
+	}
+}
+
+// isRootData reports whether the node is . or $ referring to the root data.
+func isRootData(node parse.Node, scope varScope) bool {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return scope.dot
+	case *parse.VariableNode:
+		return scope.dollar && len(n.Ident) == 1 && n.Ident[0] == "$"
+	}
+	return false
+}
+
+// indexLookup returns the variable name of an index . "NAME" or index $ "NAME" command.
+func indexLookup(n *parse.CommandNode, scope varScope) (string, bool) {
+	if len(n.Args) != 3 {
+		return "", false
+	}
+	if fn, ok := n.Args[0].(*parse.IdentifierNode); !ok || fn.Ident != "index" {
+		return "", false
+	}
+	if !isRootData(n.Args[1], scope) {
+		return "", false
+	}
+	key, ok := n.Args[2].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return key.Text, true
+}
+
+// PrintFileVars writes the variables each deployment file references and marks the ones that aren't set
+// or only set by the default declared in the VarsFileName of their directory.
+func PrintFileVars(w io.Writer, deploymentFiles []string, deploymentVars map[string]string) error {
+	fileList, err := DeploymentFilesList(deploymentFiles)
+	if err != nil {
+		return err
+	}
+	specs := map[string]VarSpecs{}
+	for _, name := range fileList {
+		if strings.HasSuffix(strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)), "noparse") {
+			continue
+		}
+		dir := filepath.Dir(name)
+		if _, ok := specs[dir]; !ok {
+			if specs[dir], err = LoadVarSpecs(dir); err != nil {
+				return err
+			}
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		required, optional, err := TemplateVars(content)
+		if err != nil {
+			return fmt.Errorf("parsing template of %v: %w", name, err)
+		}
+		if len(required)+len(optional) == 0 {
+			continue
+		}
+
+		vars := specs[dir].withDefaults(deploymentVars)
+		fmt.Fprintln(w, name)
+		for _, group := range []struct {
+			kind  string
+			names []string
+		}{{"required", required}, {"optional", optional}} {
+			for _, k := range group.names {
+				_, set := deploymentVars[k]
+				_, hasDefault := vars[k]
+				status := "set"
+				switch {
+				case !set && hasDefault:
+					status = "default"
+				case !set:
+					status = "UNSET"
+				}
+				line := fmt.Sprintf("  %-30s %-8s %s", k, group.kind, status)
+				if d := specs[dir][k].Description; d != "" {
+					line += "  # " + d
+				}
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}